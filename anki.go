@@ -2,16 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 )
 
 type AnkiRequest struct {
 	Action  string      `json:"action"`
 	Version int         `json:"version"`
 	Params  interface{} `json:"params,omitempty"`
+	Key     string      `json:"key,omitempty"`
 }
 
 type AnkiResponse struct {
@@ -20,103 +27,365 @@ type AnkiResponse struct {
 }
 
 type Anki struct {
-	connectURL string
+	connectURL  string
+	client      *http.Client
+	apiKey      string
+	sourceField string
+	sourceTags  bool
+}
+
+// defaultAnkiTimeout bounds every AnkiConnect request unless another client is configured.
+const defaultAnkiTimeout = 30 * time.Second
+
+// minAnkiConnectVersion is the oldest AnkiConnect API version the client works with.
+const minAnkiConnectVersion = 6
+
+// AnkiOption configures optional behavior of an Anki client.
+type AnkiOption func(*Anki)
+
+// WithHTTPClient sends all requests through client, e.g. to change the timeout.
+func WithHTTPClient(client *http.Client) AnkiOption {
+	return func(a *Anki) {
+		a.client = client
+	}
+}
+
+// WithAPIKey sends key with every request, as required by AnkiConnect if its apiKey
+// setting is configured.
+func WithAPIKey(key string) AnkiOption {
+	return func(a *Anki) {
+		a.apiKey = key
+	}
+}
+
+// WithSourceField writes the provenance of every added note, e.g.
+// "lecture03.pdf, p. 12 (Mutual Exclusion)", into the given field if the note type has it.
+func WithSourceField(field string) AnkiOption {
+	return func(a *Anki) {
+		a.sourceField = field
+	}
+}
+
+// WithSourceTags tags every added note with its provenance, e.g. "source::lecture03::p12".
+func WithSourceTags(enabled bool) AnkiOption {
+	return func(a *Anki) {
+		a.sourceTags = enabled
+	}
 }
 
 type Note struct {
-	DeckName  string            `json:"deckName"`
-	ModelName string            `json:"modelName"`
-	Fields    map[string]string `json:"fields"`
-	Tags      []string          `json:"tags"`
-	Options   map[string]bool   `json:"options"`
+	DeckName  string                 `json:"deckName"`
+	ModelName string                 `json:"modelName"`
+	Fields    map[string]string      `json:"fields"`
+	Tags      []string               `json:"tags"`
+	Options   map[string]interface{} `json:"options"`
 }
 
-func NewAnki(connectURL string) *Anki {
-	return &Anki{connectURL: connectURL}
+func NewAnki(connectURL string, opts ...AnkiOption) *Anki {
+	a := &Anki{connectURL: connectURL, client: &http.Client{Timeout: defaultAnkiTimeout}}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
 }
 
-func (a *Anki) invoke(action string, params interface{}) (interface{}, error) {
+func (a *Anki) invoke(ctx context.Context, action string, params interface{}) (interface{}, error) {
 	request := AnkiRequest{
 		Action:  action,
 		Version: 6,
 		Params:  params,
+		Key:     a.apiKey,
 	}
 
 	data, err := json.Marshal(request)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	resp, err := http.Post(a.connectURL, "application/json", bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.connectURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	// A failed dial other than a timeout means nothing listens at the URL; matching the
+	// *net.OpError instead of ECONNREFUSED also covers Windows, whose errno differs.
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" && !opErr.Timeout() && ctx.Err() == nil {
+		return nil, fmt.Errorf("%w: no AnkiConnect at %s", ErrAnkiNotRunning, a.connectURL)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &AnkiStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	var ankiResp AnkiResponse
 	if err := json.Unmarshal(body, &ankiResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
 	if ankiResp.Error != "" {
-		return nil, fmt.Errorf("error from AnkiConnect: %s", ankiResp.Error)
+		return nil, fmt.Errorf("error from AnkiConnect: %w", parseAnkiError(ankiResp.Error))
 	}
 
 	return ankiResp.Result, nil
 }
 
-func (a *Anki) CreateDeck(deckName string) error {
-	_, err := a.invoke("createDeck", map[string]string{"deck": deckName})
+// Handshake checks that AnkiConnect is reachable, grants access to this client and is
+// recent enough. The returned error explains how to fix the connection.
+func (a *Anki) Handshake(ctx context.Context) error {
+	result, err := a.invoke(ctx, "requestPermission", nil)
 	if err != nil {
-		return fmt.Errorf("failed to create deck: %v", err)
+		return diagnose(fmt.Errorf("failed to request permission: %w", err))
+	}
+	var perm struct {
+		Permission    string `json:"permission"`
+		RequireAPIKey bool   `json:"requireApikey"`
+	}
+	if err := decodeResult(result, &perm); err != nil {
+		return err
+	}
+	if perm.Permission != "granted" {
+		return diagnose(fmt.Errorf("%w: AnkiConnect denied access", ErrPermissionDenied))
+	}
+	if perm.RequireAPIKey && a.apiKey == "" {
+		return diagnose(fmt.Errorf("%w: AnkiConnect requires an API key", ErrPermissionDenied))
+	}
+
+	// Unlike requestPermission, version checks the API key.
+	result, err = a.invoke(ctx, "version", nil)
+	if err != nil {
+		return diagnose(fmt.Errorf("failed to get AnkiConnect version: %w", err))
+	}
+	var version int
+	if err := decodeResult(result, &version); err != nil {
+		return err
+	}
+	if version < minAnkiConnectVersion {
+		return diagnose(fmt.Errorf("%w: AnkiConnect has version %d, at least %d is needed", ErrVersionMismatch, version, minAnkiConnectVersion))
 	}
-	fmt.Printf("Deck '%s' created successfully\n", deckName)
 	return nil
 }
 
-func (a *Anki) ListDeckNames() ([]string, error) {
-	result, err := a.invoke("deckNames", nil)
+func (a *Anki) CreateDeck(ctx context.Context, deckName string) error {
+	_, err := a.invoke(ctx, "createDeck", map[string]string{"deck": deckName})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get deck names: %v", err)
+		return fmt.Errorf("failed to create deck: %w", err)
 	}
+	return nil
+}
+
+func (a *Anki) ListDeckNames(ctx context.Context) ([]string, error) {
+	result, err := a.invoke(ctx, "deckNames", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deck names: %w", err)
+	}
+
+	return toStrings(result)
+}
+
+// ModelNames returns the names of all note types in the collection.
+func (a *Anki) ModelNames(ctx context.Context) ([]string, error) {
+	result, err := a.invoke(ctx, "modelNames", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model names: %w", err)
+	}
+	return toStrings(result)
+}
+
+// ModelFieldNames returns the field names of the note type in their configured order.
+func (a *Anki) ModelFieldNames(ctx context.Context, modelName string) ([]string, error) {
+	result, err := a.invoke(ctx, "modelFieldNames", map[string]string{"modelName": modelName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get field names of %q: %w", modelName, err)
+	}
+	return toStrings(result)
+}
+
+// ModelTemplates returns the card templates of the note type keyed by card name.
+func (a *Anki) ModelTemplates(ctx context.Context, modelName string) (map[string]CardTemplate, error) {
+	result, err := a.invoke(ctx, "modelTemplates", map[string]string{"modelName": modelName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get templates of %q: %w", modelName, err)
+	}
+
+	var templates map[string]CardTemplate
+	if err := decodeResult(result, &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
 
+// NoteTypes returns the fields and templates of the named note types, fetched with a
+// single request. Note types whose fields or templates cannot be read are left out.
+func (a *Anki) NoteTypes(ctx context.Context, modelNames []string) ([]NoteType, error) {
+	if len(modelNames) == 0 {
+		return nil, nil
+	}
+	actions := make([]AnkiAction, 0, 2*len(modelNames))
+	for _, name := range modelNames {
+		actions = append(actions, ModelFieldNamesAction(name), ModelTemplatesAction(name))
+	}
+	results, err := a.Multi(ctx, actions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get note types: %w", err)
+	}
+
+	noteTypes := make([]NoteType, 0, len(modelNames))
+	for i, name := range modelNames {
+		var fields []string
+		var templates map[string]CardTemplate
+		if results[2*i].Decode(&fields) != nil || results[2*i+1].Decode(&templates) != nil || len(fields) == 0 {
+			continue
+		}
+
+		nt := NoteType{Name: name, Fields: fields, Templates: templates}
+		for _, t := range templates {
+			if strings.Contains(t.Front, "{{cloze:") {
+				nt.Cloze = true
+			}
+		}
+		noteTypes = append(noteTypes, nt)
+	}
+	return noteTypes, nil
+}
+
+// AddNotesError reports the notes of an AddNotes call that could not be added.
+type AddNotesError struct {
+	// Errs holds one entry per note passed to AddNotes; it is nil for notes that were added.
+	Errs []error
+}
+
+func (e *AddNotesError) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e.Errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d of %d notes could not be added: %v", failed, len(e.Errs), first)
+}
+
+// NoteErrors returns the per-note errors, nil for every note that was added.
+func (e *AddNotesError) NoteErrors() []error {
+	return e.Errs
+}
+
+// AddNotes adds the notes to the deck and returns the ID of every created note, or 0 for
+// notes that could not be added. The keys of every note are mapped onto the exact field
+// names of the note type, so generated keys may differ in case and punctuation.
+// Provenance keys are written to the source field and tags as configured.
+//
+// Notes that Anki rejects do not prevent the others from being added; they are reported
+// by an *AddNotesError with the reason for every rejected note.
+func (a *Anki) AddNotes(ctx context.Context, deckName, modelName string, notes []map[string]string) ([]int64, error) {
+	return a.addNotes(ctx, deckName, modelName, notes, false)
+}
+
+// CreateDeckAndAddNotes is like AddNotes, but first creates the deck in the same request
+// if it does not exist yet.
+func (a *Anki) CreateDeckAndAddNotes(ctx context.Context, deckName, modelName string, notes []map[string]string) ([]int64, error) {
+	return a.addNotes(ctx, deckName, modelName, notes, true)
+}
+
+func (a *Anki) addNotes(ctx context.Context, deckName, modelName string, notes []map[string]string, createDeck bool) ([]int64, error) {
+	fields, err := a.ModelFieldNames(ctx, modelName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add notes: %w", err)
+	}
+
+	// Every note is added by its own action, as AnkiConnect's addNotes fails the whole
+	// request with a single message if any note is rejected.
+	var actions []AnkiAction
+	if createDeck {
+		actions = append(actions, CreateDeckAction(deckName))
+	}
+	for _, note := range notes {
+		actions = append(actions, AddNoteAction(a.toNote(deckName, modelName, fields, note)))
+	}
+	results, err := a.Multi(ctx, actions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add notes: %w", err)
+	}
+	if createDeck {
+		if results[0].Err != nil {
+			return nil, fmt.Errorf("failed to create deck: %w", results[0].Err)
+		}
+		results = results[1:]
+	}
+
+	ids := make([]int64, len(notes))
+	errs := make([]error, len(notes))
+	failed := false
+	for i, r := range results {
+		if err := r.Decode(&ids[i]); err != nil {
+			errs[i] = err
+			failed = true
+		}
+	}
+	if failed {
+		return ids, &AddNotesError{Errs: errs}
+	}
+	return ids, nil
+}
+
+// toNote converts a generated note into an AnkiConnect note of the given note type.
+func (a *Anki) toNote(deckName, modelName string, fields []string, note map[string]string) Note {
+	n := Note{
+		DeckName:  deckName,
+		ModelName: modelName,
+		Fields:    mapFields(note, fields),
+		Tags:      []string{},
+		Options:   map[string]interface{}{"allowDuplicate": false, "duplicateScope": "deck"},
+	}
+	if a.sourceField != "" && slices.Contains(fields, a.sourceField) {
+		n.Fields[a.sourceField] = formatSource(note)
+	}
+	if tag := sourceTag(note); a.sourceTags && tag != "" {
+		n.Tags = append(n.Tags, tag)
+	}
+	return n
+}
+
+// toStrings converts a JSON array result into a string slice.
+func toStrings(result interface{}) ([]string, error) {
 	slice, ok := result.([]interface{})
 	if !ok {
 		return nil, fmt.Errorf("unexpected result type: %T", result)
 	}
 
-	names := make([]string, len(slice))
+	strs := make([]string, len(slice))
 	for i, v := range slice {
 		str, ok := v.(string)
 		if !ok {
 			return nil, fmt.Errorf("unexpected element type at index %d: %T", i, v)
 		}
-		names[i] = str
+		strs[i] = str
 	}
-
-	return names, nil
+	return strs, nil
 }
 
-func (a *Anki) AddNotes(deckName, modelName string, notes []map[string]string) error {
-	var noteData []Note
-	for _, note := range notes {
-		noteData = append(noteData, Note{
-			DeckName:  deckName,
-			ModelName: modelName,
-			Fields:    note,
-			Options:   map[string]bool{"allowDuplicate": false},
-		})
-	}
-
-	_, err := a.invoke("addNotes", map[string]interface{}{"notes": noteData})
+// decodeResult converts a generic JSON result into v.
+func decodeResult(result interface{}, v interface{}) error {
+	data, err := json.Marshal(result)
 	if err != nil {
-		return fmt.Errorf("failed to add notes: %v", err)
+		return fmt.Errorf("failed to marshal result: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unexpected result: %w", err)
 	}
-
 	return nil
 }
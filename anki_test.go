@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAnkiConnect is an in-memory AnkiConnect server knowing the Basic and Cloze note types.
+type fakeAnkiConnect struct {
+	// apiKey, if set, must be sent with every request and every action of multi.
+	apiKey string
+
+	mu    sync.Mutex
+	decks []string
+	notes []Note
+	keys  []string
+}
+
+var fakeModelFields = map[string][]string{
+	"Basic": {"Front", "Back"},
+	"Cloze": {"Text", "Back Extra"},
+}
+
+// newFakeAnkiConnect starts a fakeAnkiConnect with the given decks and returns it with a
+// client talking to it.
+func newFakeAnkiConnect(t *testing.T, apiKey string, decks ...string) (*fakeAnkiConnect, *Anki) {
+	t.Helper()
+	f := &fakeAnkiConnect{apiKey: apiKey, decks: decks}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, NewAnki(srv.URL, WithAPIKey(apiKey))
+}
+
+type fakeRequest struct {
+	Action  string          `json:"action"`
+	Version int             `json:"version"`
+	Params  json.RawMessage `json:"params"`
+	Key     string          `json:"key"`
+}
+
+func (f *fakeAnkiConnect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req fakeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result, errMsg := f.handle(req)
+	json.NewEncoder(w).Encode(AnkiResponse{Result: result, Error: errMsg})
+}
+
+func (f *fakeAnkiConnect) handle(req fakeRequest) (any, string) {
+	f.keys = append(f.keys, req.Key)
+	if req.Action == "requestPermission" {
+		return map[string]any{"permission": "granted", "requireApikey": f.apiKey != ""}, ""
+	}
+	if f.apiKey != "" && req.Key != f.apiKey {
+		return nil, "valid api key must be provided"
+	}
+	var params struct {
+		Actions []fakeRequest `json:"actions"`
+		Deck    string        `json:"deck"`
+		Model   string        `json:"modelName"`
+		Query   string        `json:"query"`
+		Note    Note          `json:"note"`
+	}
+	json.Unmarshal(req.Params, &params)
+
+	switch req.Action {
+	case "version":
+		return 6, ""
+	case "multi":
+		results := make([]AnkiResponse, len(params.Actions))
+		for i, action := range params.Actions {
+			results[i].Result, results[i].Error = f.handle(action)
+		}
+		return results, ""
+	case "deckNames":
+		return append([]string{}, f.decks...), ""
+	case "createDeck":
+		if !slices.Contains(f.decks, params.Deck) {
+			f.decks = append(f.decks, params.Deck)
+		}
+		return 1, ""
+	case "modelFieldNames":
+		fields, ok := fakeModelFields[params.Model]
+		if !ok {
+			return nil, "model was not found: " + params.Model
+		}
+		return fields, ""
+	case "addNote":
+		if msg := f.check(params.Note); msg != "" {
+			return nil, msg
+		}
+		f.notes = append(f.notes, params.Note)
+		return int64(len(f.notes)), ""
+	case "canAddNotes":
+		var notes []Note
+		json.Unmarshal(req.Params, &struct {
+			Notes *[]Note `json:"notes"`
+		}{&notes})
+		canAdd := make([]bool, len(notes))
+		for i, note := range notes {
+			canAdd[i] = f.check(note) == ""
+		}
+		return canAdd, ""
+	case "findNotes":
+		var ids []int64
+		for i, note := range f.notes {
+			if deckQuery(note.DeckName) == params.Query {
+				ids = append(ids, int64(i+1))
+			}
+		}
+		return ids, ""
+	case "notesInfo":
+		var ids []int64
+		json.Unmarshal(req.Params, &struct {
+			Notes *[]int64 `json:"notes"`
+		}{&ids})
+		infos := make([]NoteInfo, len(ids))
+		for i, id := range ids {
+			note := f.notes[id-1]
+			infos[i] = NoteInfo{NoteID: id, ModelName: note.ModelName, Fields: map[string]NoteField{}}
+			for order, field := range fakeModelFields[note.ModelName] {
+				infos[i].Fields[field] = NoteField{Value: note.Fields[field], Order: order}
+			}
+		}
+		return infos, ""
+	}
+	return nil, "unsupported action"
+}
+
+// check returns the error AnkiConnect gives for adding note, or "" if it can be added.
+func (f *fakeAnkiConnect) check(note Note) string {
+	if !slices.Contains(f.decks, note.DeckName) {
+		return "deck was not found: " + note.DeckName
+	}
+	fields, ok := fakeModelFields[note.ModelName]
+	if !ok {
+		return "model was not found: " + note.ModelName
+	}
+	if note.Fields[fields[0]] == "" {
+		return "cannot create note because it is empty"
+	}
+	for _, n := range f.notes {
+		if n.DeckName == note.DeckName && n.Fields[fields[0]] == note.Fields[fields[0]] {
+			return "cannot create note because it is a duplicate"
+		}
+	}
+	return ""
+}
+
+// fronts returns the first fields of the notes in deck.
+func (f *fakeAnkiConnect) fronts(deck string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var fronts []string
+	for _, n := range f.notes {
+		if n.DeckName == deck {
+			fronts = append(fronts, n.Fields[fakeModelFields[n.ModelName][0]])
+		}
+	}
+	return fronts
+}
+
+func TestAddNotes(t *testing.T) {
+	f, anki := newFakeAnkiConnect(t, "", "Default")
+	notes := []map[string]string{
+		{"Front": "What is a mutex?", "Back": "A lock."},
+		{"Front": "What is a mutex?", "Back": "A lock, again."},
+		{"Front": "", "Back": "No front."},
+	}
+	if _, err := anki.AddNotes(context.Background(), "Default", "Basic", notes[:1]); err != nil {
+		t.Fatalf("AddNotes: %v", err)
+	}
+	notes[0] = map[string]string{"Front": "What is a semaphore?", "Back": "A counter."}
+	ids, err := anki.AddNotes(context.Background(), "Default", "Basic", notes)
+	var addErr *AddNotesError
+	if !errors.As(err, &addErr) {
+		t.Fatalf("AddNotes error = %v, want *AddNotesError", err)
+	}
+	if ids[0] == 0 || ids[1] != 0 || ids[2] != 0 {
+		t.Errorf("AddNotes ids = %v, want only the first note added", ids)
+	}
+	if addErr.Errs[0] != nil || !errors.Is(addErr.Errs[1], ErrDuplicateNote) || !errors.Is(addErr.Errs[2], ErrFieldMismatch) {
+		t.Errorf("AddNotes errors = %v", addErr.Errs)
+	}
+	if got := f.fronts("Default"); len(got) != 2 {
+		t.Errorf("deck contains %q, want two notes", got)
+	}
+
+	_, err = anki.AddNotes(context.Background(), "Missing", "Basic", notes[:1])
+	if !errors.As(err, &addErr) || !errors.Is(addErr.Errs[0], ErrDeckNotFound) {
+		t.Errorf("AddNotes to a missing deck = %v, want %v", err, ErrDeckNotFound)
+	}
+}
+
+func TestCreateDeckAndAddNotes(t *testing.T) {
+	f, anki := newFakeAnkiConnect(t, "")
+	notes := []map[string]string{{"Front": "What is a mutex?", "Back": "A lock."}}
+	ids, err := anki.CreateDeckAndAddNotes(context.Background(), "Lectures", "Basic", notes)
+	if err != nil {
+		t.Fatalf("CreateDeckAndAddNotes: %v", err)
+	}
+	if len(ids) != 1 || ids[0] == 0 {
+		t.Errorf("CreateDeckAndAddNotes ids = %v", ids)
+	}
+	if !slices.Contains(f.decks, "Lectures") {
+		t.Errorf("decks = %q, want Lectures created", f.decks)
+	}
+}
+
+func TestMultiReportsErrorsPerAction(t *testing.T) {
+	_, anki := newFakeAnkiConnect(t, "", "Default")
+	results, err := anki.Multi(context.Background(), ModelFieldNamesAction("Basic"), ModelFieldNamesAction("Missing"))
+	if err != nil {
+		t.Fatalf("Multi: %v", err)
+	}
+	var fields []string
+	if err := results[0].Decode(&fields); err != nil || !slices.Equal(fields, fakeModelFields["Basic"]) {
+		t.Errorf("first action = %q, %v", fields, err)
+	}
+	if err := results[1].Decode(&fields); !errors.Is(err, ErrModelNotFound) {
+		t.Errorf("second action error = %v, want %v", err, ErrModelNotFound)
+	}
+}
+
+func TestCheckDuplicates(t *testing.T) {
+	_, anki := newFakeAnkiConnect(t, "", "Default")
+	existing := []map[string]string{{"Front": "What is a <b>mutex</b>?", "Back": "A lock."}}
+	if _, err := anki.AddNotes(context.Background(), "Default", "Basic", existing); err != nil {
+		t.Fatalf("AddNotes: %v", err)
+	}
+	notes := []map[string]string{
+		{"Front": "What is a <b>mutex</b>?", "Back": "A lock."},
+		{"Front": "what is a mutex", "Back": "A lock."},
+		{"Front": "What is a semaphore?", "Back": "A counter."},
+	}
+	deckNotes, err := anki.DeckNotes(context.Background(), "Default")
+	if err != nil {
+		t.Fatalf("DeckNotes: %v", err)
+	}
+	if want := [][]string{{"What is a <b>mutex</b>?", "A lock."}}; !reflect.DeepEqual(deckNotes, want) {
+		t.Errorf("DeckNotes = %q, want %q", deckNotes, want)
+	}
+	status, err := anki.CheckDuplicates(context.Background(), "Default", "Basic", notes, deckNotes)
+	if err != nil {
+		t.Fatalf("CheckDuplicates: %v", err)
+	}
+	want := []string{DuplicateExact, DuplicateSimilar, DuplicateNone}
+	if !slices.Equal(status, want) {
+		t.Errorf("CheckDuplicates = %q, want %q", status, want)
+	}
+}
+
+func TestAnkiRequestsHonorContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a busy Anki does not answer in time
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := NewAnki(srv.URL).ListDeckNames(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ListDeckNames with an expired context = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestAnkiNotRunning(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	if _, err := NewAnki(url).ListDeckNames(context.Background()); !errors.Is(err, ErrAnkiNotRunning) {
+		t.Errorf("ListDeckNames with nothing listening = %v, want %v", err, ErrAnkiNotRunning)
+	}
+}
+
+func TestAnkiStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	_, err := NewAnki(srv.URL).ListDeckNames(context.Background())
+	var statusErr *AnkiStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusForbidden {
+		t.Errorf("ListDeckNames with status 403 = %v, want *AnkiStatusError", err)
+	}
+	if errors.Is(err, ErrAnkiNotRunning) {
+		t.Errorf("ListDeckNames with status 403 = %v, reported as not running", err)
+	}
+}
+
+func TestMultiSendsAPIKeyWithEveryAction(t *testing.T) {
+	f, anki := newFakeAnkiConnect(t, "secret", "Default")
+	results, err := anki.Multi(context.Background(), ModelFieldNamesAction("Basic"), FindNotesAction(deckQuery("Default")))
+	if err != nil {
+		t.Fatalf("Multi: %v", err)
+	}
+	for i, r := range results {
+		if r.Err != nil {
+			t.Errorf("action %d failed: %v", i, r.Err)
+		}
+	}
+	for i, key := range f.keys {
+		if key != "secret" {
+			t.Errorf("request %d was sent with key %q", i, key)
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	_, anki := newFakeAnkiConnect(t, "secret")
+	if err := anki.Handshake(context.Background()); err != nil {
+		t.Errorf("Handshake: %v", err)
+	}
+
+	for _, key := range []string{"", "wrong"} {
+		_, anki = newFakeAnkiConnect(t, "secret")
+		anki.apiKey = key
+		if err := anki.Handshake(context.Background()); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Handshake with key %q = %v, want %v", key, err, ErrPermissionDenied)
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req fakeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Action == "version" {
+			json.NewEncoder(w).Encode(AnkiResponse{Result: 5})
+			return
+		}
+		json.NewEncoder(w).Encode(AnkiResponse{Result: map[string]any{"permission": "granted"}})
+	}))
+	defer srv.Close()
+	if err := NewAnki(srv.URL).Handshake(context.Background()); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Handshake with an old AnkiConnect = %v, want %v", err, ErrVersionMismatch)
+	}
+}
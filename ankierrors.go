@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Errors reported by AnkiConnect, matched with errors.Is. AnkiConnect only returns
+// messages, so they are recognized by their wording.
+var (
+	// ErrAnkiNotRunning is returned when nothing accepts connections at the AnkiConnect
+	// URL, which usually means that Anki is not running or AnkiConnect is not installed.
+	ErrAnkiNotRunning = errors.New("Anki is not running")
+	// ErrDuplicateNote is returned for a note whose first field already exists in the deck.
+	ErrDuplicateNote = errors.New("duplicate note")
+	// ErrDeckNotFound is returned when the deck does not exist.
+	ErrDeckNotFound = errors.New("deck not found")
+	// ErrModelNotFound is returned when the note type does not exist.
+	ErrModelNotFound = errors.New("note type not found")
+	// ErrFieldMismatch is returned when the fields of a note do not fit its note type, e.g.
+	// because none of them maps onto the first field.
+	ErrFieldMismatch = errors.New("fields do not match the note type")
+	// ErrPermissionDenied is returned when AnkiConnect rejects the request, e.g. because
+	// its API key is missing or wrong.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrVersionMismatch is returned for actions the installed AnkiConnect does not support.
+	ErrVersionMismatch = errors.New("unsupported AnkiConnect version")
+)
+
+// ankiErrorKinds maps parts of AnkiConnect's messages to the error they stand for.
+var ankiErrorKinds = []struct {
+	substr string
+	err    error
+}{
+	{"it is a duplicate", ErrDuplicateNote},
+	{"deck was not found", ErrDeckNotFound},
+	{"model was not found", ErrModelNotFound},
+	{"it is empty", ErrFieldMismatch},
+	{"field was not found", ErrFieldMismatch},
+	{"api key", ErrPermissionDenied},
+	{"permission", ErrPermissionDenied},
+	{"unsupported action", ErrVersionMismatch},
+}
+
+// AnkiError is an error message returned by AnkiConnect. It unwraps to one of the errors
+// above if the message is recognized.
+type AnkiError struct {
+	Message string
+	Err     error
+}
+
+// parseAnkiError turns an AnkiConnect error message into an *AnkiError.
+func parseAnkiError(message string) *AnkiError {
+	lower := strings.ToLower(message)
+	for _, k := range ankiErrorKinds {
+		if strings.Contains(lower, k.substr) {
+			return &AnkiError{Message: message, Err: k.err}
+		}
+	}
+	return &AnkiError{Message: message}
+}
+
+func (e *AnkiError) Error() string {
+	return e.Message
+}
+
+func (e *AnkiError) Unwrap() error {
+	return e.Err
+}
+
+// DuplicateNote reports that the note already exists in the deck.
+func (e *AnkiError) DuplicateNote() bool {
+	return e.Err == ErrDuplicateNote
+}
+
+// MissingDeck reports that the deck does not exist.
+func (e *AnkiError) MissingDeck() bool {
+	return e.Err == ErrDeckNotFound
+}
+
+// diagnose adds advice on how to fix a failed connection to AnkiConnect to err.
+func diagnose(err error) error {
+	var hint string
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrAnkiNotRunning):
+		hint = "start Anki and check that the AnkiConnect add-on is installed"
+	case errors.Is(err, ErrPermissionDenied):
+		hint = "check the API key (-anki-key, ANKICONNECT_API_KEY or the config file) and that AnkiConnect allows this client"
+	case errors.Is(err, ErrVersionMismatch):
+		hint = "update the AnkiConnect add-on"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		hint = "Anki did not answer in time; it may be busy or waiting for a dialog to be closed"
+	default:
+		return err
+	}
+	return fmt.Errorf("%w; %s", err, hint)
+}
+
+// AnkiStatusError is returned when AnkiConnect answers with a status other than 200 OK.
+type AnkiStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *AnkiStatusError) Error() string {
+	return "unexpected status from AnkiConnect: " + e.Status
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestParseAnkiError(t *testing.T) {
+	tests := []struct {
+		message string
+		want    error
+	}{
+		{message: "cannot create note because it is a duplicate", want: ErrDuplicateNote},
+		{message: "deck was not found: Lectures", want: ErrDeckNotFound},
+		{message: "model was not found: Vocab", want: ErrModelNotFound},
+		{message: "cannot create note because it is empty", want: ErrFieldMismatch},
+		{message: "valid api key must be provided", want: ErrPermissionDenied},
+		{message: "unsupported action", want: ErrVersionMismatch},
+		{message: "something else went wrong", want: nil},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("error from AnkiConnect: %w", parseAnkiError(tt.message))
+		var ae *AnkiError
+		if !errors.As(err, &ae) || ae.Message != tt.message {
+			t.Errorf("parseAnkiError(%q) does not unwrap to an *AnkiError with the message", tt.message)
+			continue
+		}
+		if ae.Err != tt.want {
+			t.Errorf("parseAnkiError(%q).Err = %v, want %v", tt.message, ae.Err, tt.want)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("errors.Is(parseAnkiError(%q), %v) = false", tt.message, tt.want)
+		}
+	}
+	if !parseAnkiError("cannot create note because it is a duplicate").DuplicateNote() {
+		t.Error("DuplicateNote() = false for a duplicate")
+	}
+	if !parseAnkiError("deck was not found: Lectures").MissingDeck() {
+		t.Error("MissingDeck() = false for a missing deck")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// anthropicVersion is the version of the Messages API the requests are written against.
+const anthropicVersion = "2023-06-01"
+
+// anthropicMaxTokens limits the length of a response; a chunk of a lecture rarely yields
+// more notes than fit into it.
+const anthropicMaxTokens = 16384
+
+// AnthropicLLM generates notes through the Anthropic Messages API. The PDF text is
+// extracted locally, so only plain text is sent to the server. The notes are requested
+// as the input of a forced tool call, which makes the model follow the note schema.
+type AnthropicLLM struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+	model   string
+}
+
+type anthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type anthropicRequest struct {
+	Model      string              `json:"model"`
+	MaxTokens  int                 `json:"max_tokens"`
+	System     string              `json:"system"`
+	Messages   []chatMessage       `json:"messages"`
+	Tools      []anthropicTool     `json:"tools"`
+	ToolChoice anthropicToolChoice `json:"tool_choice"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type  string          `json:"type"`
+		Name  string          `json:"name"`
+		Input json.RawMessage `json:"input"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+}
+
+func init() {
+	registerProvider("anthropic", provider{
+		Required: []string{"ANTHROPIC_API_KEY", "ANTHROPIC_MODEL"},
+		Defaults: map[string]string{
+			"ANTHROPIC_BASE_URL": "https://api.anthropic.com/v1",
+		},
+		ModelKey: "ANTHROPIC_MODEL",
+		New: func(_ context.Context, cfg providerConfig) (LLM, error) {
+			return NewAnthropicLLM(cfg["ANTHROPIC_BASE_URL"], cfg["ANTHROPIC_API_KEY"], cfg["ANTHROPIC_MODEL"])
+		},
+	})
+}
+
+// NewAnthropicLLM creates an LLM for the Messages API below baseURL, e.g.
+// "https://api.anthropic.com/v1".
+func NewAnthropicLLM(baseURL, apiKey, model string) (LLM, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("base URL must not be empty")
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("API key must not be empty")
+	}
+	if model == "" {
+		return nil, fmt.Errorf("model must not be empty")
+	}
+	return &AnthropicLLM{
+		client:  &http.Client{},
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+	}, nil
+}
+
+func (a *AnthropicLLM) GenerateAnkiNotes(ctx context.Context, r io.Reader, noteModel string) ([]map[string]string, error) {
+	instructions, err := promptFor(noteModel)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := extractPDFText(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return a.generate(ctx, noteModel, instructions, text)
+}
+
+// RefineNote rewrites a single note following instruction.
+func (a *AnthropicLLM) RefineNote(ctx context.Context, noteModel string, note map[string]string, instruction string) ([]map[string]string, error) {
+	instructions, input, err := refineRequest(noteModel, note, instruction)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := a.generate(ctx, noteModel, instructions, input)
+	if err != nil {
+		return nil, err
+	}
+	return finishRefined(noteModel, note, notes)
+}
+
+// generate sends the system prompt and user text and parses the response as notes of noteModel.
+func (a *AnthropicLLM) generate(ctx context.Context, noteModel, instructions, text string) ([]map[string]string, error) {
+	schema, err := schemaFor(noteModel)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tool inputs must be objects, so the notes array is wrapped like for OpenAI.
+	const toolName = "anki_notes"
+	req := anthropicRequest{
+		Model:     a.model,
+		MaxTokens: anthropicMaxTokens,
+		System:    instructions,
+		Messages:  []chatMessage{{Role: "user", Content: text}},
+		Tools: []anthropicTool{{
+			Name:        toolName,
+			Description: "Saves the generated Anki notes.",
+			InputSchema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"notes": jsonSchema(schema)},
+				"required":   []string{"notes"},
+			},
+		}},
+		ToolChoice: anthropicToolChoice{Type: "tool", Name: toolName},
+	}
+
+	header := http.Header{}
+	header.Set("x-api-key", a.apiKey)
+	header.Set("anthropic-version", anthropicVersion)
+	var resp anthropicResponse
+	if err := postJSON(ctx, a.client, a.baseURL+"/messages", header, req, &resp); err != nil {
+		return nil, err
+	}
+
+	if resp.StopReason == "max_tokens" {
+		return nil, fmt.Errorf("response exceeded %d tokens", anthropicMaxTokens)
+	}
+	for _, block := range resp.Content {
+		if block.Type != "tool_use" || block.Name != toolName {
+			continue
+		}
+		var out struct {
+			Notes []map[string]string `json:"notes"`
+		}
+		if err := json.Unmarshal(block.Input, &out); err != nil {
+			return nil, fmt.Errorf("failed to parse generated notes: %v", err)
+		}
+		return out.Notes, nil
+	}
+	return nil, fmt.Errorf("messages API returned no notes")
+}
+
+func (a *AnthropicLLM) Close() error {
+	a.client.CloseIdleConnections()
+	return nil
+}
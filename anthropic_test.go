@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAnthropicRequestsNotesThroughTool(t *testing.T) {
+	fakePoppler(t, "A mutex is a lock.")
+	var req anthropicRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("x-api-key") != "secret" || r.Header.Get("anthropic-version") == "" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]any{
+			"content": []any{
+				map[string]any{"type": "text", "text": "Here are the notes."},
+				map[string]any{"type": "tool_use", "name": "anki_notes", "input": map[string]any{
+					"notes": []any{map[string]string{"Front": "What is a mutex?", "Back": "A lock."}},
+				}},
+			},
+			"stop_reason": "tool_use",
+		})
+	}))
+	defer srv.Close()
+
+	llm, err := NewAnthropicLLM(srv.URL+"/v1", "secret", "test-model")
+	if err != nil {
+		t.Fatalf("NewAnthropicLLM: %v", err)
+	}
+	notes, err := llm.GenerateAnkiNotes(context.Background(), strings.NewReader("%PDF-1.4"), "Basic")
+	if err != nil {
+		t.Fatalf("GenerateAnkiNotes: %v", err)
+	}
+	if len(notes) != 1 || notes[0]["Front"] != "What is a mutex?" {
+		t.Errorf("notes = %v, want the note of the tool call", notes)
+	}
+
+	if req.Model != "test-model" || req.System == "" || len(req.Messages) != 1 || !strings.Contains(req.Messages[0].Content, "A mutex is a lock.") {
+		t.Errorf("request = %+v, want the model, the prompt and the PDF text", req)
+	}
+	if len(req.Tools) != 1 || req.ToolChoice.Type != "tool" || req.ToolChoice.Name != req.Tools[0].Name {
+		t.Fatalf("tools = %+v with choice %+v, want a single forced tool", req.Tools, req.ToolChoice)
+	}
+	schema := req.Tools[0].InputSchema
+	notesSchema, _ := schema["properties"].(map[string]any)["notes"].(map[string]any)
+	required, _ := schema["required"].([]any)
+	if schema["type"] != "object" || notesSchema["type"] != "array" || !slices.Equal(required, []any{"notes"}) {
+		t.Errorf("input schema = %v, want an object with a required notes array", schema)
+	}
+}
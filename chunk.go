@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChunkOptions configures how ChunkedLLM splits a document.
+type ChunkOptions struct {
+	// PagesPerChunk is the number of pages sent in a single request. Zero disables chunking.
+	PagesPerChunk int
+	// Overlap is the number of pages shared by consecutive chunks, so content spanning a
+	// chunk boundary is seen as a whole at least once.
+	Overlap int
+	// Parallelism bounds the number of chunks generated at the same time.
+	Parallelism int
+	// Timeout bounds the generation of a single chunk. Zero means no timeout.
+	Timeout time.Duration
+}
+
+// ChunkedLLM splits large PDFs into page ranges and generates the notes of every range
+// with the wrapped LLM concurrently, merging the results in page order.
+type ChunkedLLM struct {
+	llm  LLM
+	opts ChunkOptions
+}
+
+// NewChunkedLLM wraps llm so that documents are generated in chunks as configured by opts.
+func NewChunkedLLM(llm LLM, opts ChunkOptions) *ChunkedLLM {
+	if opts.Parallelism < 1 {
+		opts.Parallelism = 1
+	}
+	return &ChunkedLLM{llm: llm, opts: opts}
+}
+
+func (c *ChunkedLLM) GenerateAnkiNotes(ctx context.Context, r io.Reader, noteModel string) ([]map[string]string, error) {
+	return c.GenerateAnkiNotesProgress(ctx, r, noteModel, "", nil)
+}
+
+// RefineNote passes the refinement of a single note on to the wrapped LLM, bounded by the
+// chunk timeout.
+func (c *ChunkedLLM) RefineNote(ctx context.Context, noteModel string, note map[string]string, instruction string) ([]map[string]string, error) {
+	refiner, ok := c.llm.(Refiner)
+	if !ok {
+		return nil, fmt.Errorf("refining notes is not supported by this LLM")
+	}
+	if c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+	notes, err := refiner.RefineNote(ctx, noteModel, note, instruction)
+	return notes, markTransient(err)
+}
+
+// GenerateAnkiNotesProgress works like GenerateAnkiNotes but only generates notes for
+// the pages in spec (e.g. "45-78,90"), or the whole document if spec is empty, and calls
+// progress, if not nil, every time a chunk has been generated. Errors that may go away
+// on a retry implement Transient() bool.
+func (c *ChunkedLLM) GenerateAnkiNotesProgress(ctx context.Context, r io.Reader, noteModel, spec string, progress func(done, total int)) ([]map[string]string, error) {
+	notes, err := c.generateProgress(ctx, r, noteModel, spec, progress)
+	return notes, markTransient(err)
+}
+
+func (c *ChunkedLLM) generateProgress(ctx context.Context, r io.Reader, noteModel, spec string, progress func(done, total int)) ([]map[string]string, error) {
+	ranges, err := parsePageRanges(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "anki-llm-*.pdf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		return nil, fmt.Errorf("failed to buffer pdf: %v", err)
+	}
+
+	var chunks [][]pageRange
+	if c.opts.PagesPerChunk > 0 || len(ranges) > 0 {
+		pageCount, err := pdfPageCount(ctx, tmp.Name())
+		if errors.Is(err, exec.ErrNotFound) && len(ranges) == 0 {
+			// Without poppler the document cannot be split; send it as a whole.
+			pageCount = 0
+		} else if err != nil {
+			return nil, err
+		}
+		if pageCount > 0 {
+			pages, err := selectPages(ranges, pageCount)
+			if err != nil {
+				return nil, err
+			}
+			chunks = chunkPages(pages, c.opts.PagesPerChunk, c.opts.Overlap)
+		}
+	}
+
+	if len(chunks) <= 1 && len(ranges) == 0 {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to rewind pdf: %v", err)
+		}
+		notes, err := c.generate(ctx, tmp, noteModel)
+		if err != nil {
+			return nil, err
+		}
+		stampSourceFile(notes, r)
+		if progress != nil {
+			progress(1, 1)
+		}
+		return notes, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		done     int
+		firstErr error
+		results  = make([][]map[string]string, len(chunks))
+		sem      = make(chan struct{}, c.opts.Parallelism)
+	)
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			notes, err := c.generateChunk(ctx, tmp.Name(), chunk, noteModel)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("pages %s: %w", formatPageRanges(chunk), err)
+					cancel()
+				}
+				return
+			}
+			results[i] = notes
+			done++
+			if progress != nil {
+				progress(done, len(chunks))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	notes := mergeNotes(noteModel, results)
+	stampSourceFile(notes, r)
+	return notes, nil
+}
+
+func (c *ChunkedLLM) generateChunk(ctx context.Context, path string, chunk []pageRange, noteModel string) ([]map[string]string, error) {
+	data, err := slicePDF(ctx, path, chunk)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := c.generate(ctx, bytes.NewReader(data), noteModel)
+	if err != nil {
+		return nil, err
+	}
+	var pages []int
+	for _, r := range chunk {
+		for page := r.First; page <= r.Last; page++ {
+			pages = append(pages, page)
+		}
+	}
+	remapSourcePages(notes, pages)
+	return notes, nil
+}
+
+func (c *ChunkedLLM) generate(ctx context.Context, r io.Reader, noteModel string) ([]map[string]string, error) {
+	if c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+	return c.llm.GenerateAnkiNotes(ctx, r, noteModel)
+}
+
+func (c *ChunkedLLM) Close() error {
+	return c.llm.Close()
+}
+
+// chunkPages splits pages into consecutive chunks of size pages, each sharing overlap
+// pages with its predecessor, and returns the page ranges of every chunk. A size of zero
+// puts all pages into a single chunk.
+func chunkPages(pages []int, size, overlap int) [][]pageRange {
+	if len(pages) == 0 {
+		return nil
+	}
+	if size <= 0 {
+		size = len(pages)
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+	var chunks [][]pageRange
+	for first := 0; ; first += size - overlap {
+		last := min(first+size, len(pages))
+		chunks = append(chunks, toPageRanges(pages[first:last]))
+		if last == len(pages) {
+			return chunks
+		}
+	}
+}
+
+// formatPageRanges formats ranges the way parsePageRanges accepts them.
+func formatPageRanges(ranges []pageRange) string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// mergeNotes concatenates the notes of all chunks in order, dropping notes whose first
+// field repeats an earlier one, as overlapping chunks generate such notes once per chunk.
+// Formatting, case and punctuation of the first field are ignored.
+func mergeNotes(noteModel string, chunks [][]map[string]string) []map[string]string {
+	var fields []string
+	if nt, err := lookupNoteType(noteModel); err == nil {
+		fields = nt.Fields
+	}
+	seen := map[string]bool{}
+	var merged []map[string]string
+	for _, notes := range chunks {
+		for _, note := range notes {
+			key := mergeKey(note, fields)
+			if key != "" && seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, note)
+		}
+	}
+	return merged
+}
+
+// mergeKey identifies a note by its normalized first field, or by all fields apart from
+// the provenance keys if the first field is unknown or empty.
+func mergeKey(note map[string]string, fields []string) string {
+	if len(fields) > 0 {
+		if key := normalizeText(mapFields(note, fields)[fields[0]]); key != "" {
+			return key
+		}
+	}
+	content := maps.Clone(note)
+	delete(content, sourcePageKey)
+	delete(content, sourceSectionKey)
+	delete(content, sourceFileKey)
+	// json.Marshal sorts map keys, so equal notes have equal keys.
+	key, err := json.Marshal(content)
+	if err != nil {
+		return ""
+	}
+	return string(key)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChunkPages(t *testing.T) {
+	pages := func(first, last int) []int {
+		var p []int
+		for i := first; i <= last; i++ {
+			p = append(p, i)
+		}
+		return p
+	}
+	tests := []struct {
+		name          string
+		pages         []int
+		size, overlap int
+		want          string
+	}{
+		{name: "no pages", pages: nil, size: 10, want: ""},
+		{name: "single chunk", pages: pages(1, 10), size: 0, want: "1-10"},
+		{name: "even split", pages: pages(1, 10), size: 5, want: "1-5|6-10"},
+		{name: "remainder", pages: pages(1, 11), size: 5, want: "1-5|6-10|11"},
+		{name: "overlap", pages: pages(1, 10), size: 4, overlap: 1, want: "1-4|4-7|7-10"},
+		{name: "overlap as large as size is ignored", pages: pages(1, 4), size: 2, overlap: 2, want: "1-2|3-4"},
+		{name: "gaps", pages: []int{1, 2, 3, 10, 11}, size: 4, want: "1-3,10|11"},
+	}
+	for _, tt := range tests {
+		var got string
+		for i, chunk := range chunkPages(tt.pages, tt.size, tt.overlap) {
+			if i > 0 {
+				got += "|"
+			}
+			got += formatPageRanges(chunk)
+		}
+		if got != tt.want {
+			t.Errorf("%s: chunkPages = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMergeNotes(t *testing.T) {
+	chunks := [][]map[string]string{
+		{
+			{"Front": "What is a mutex?", "Back": "A lock.", sourcePageKey: "3"},
+			{"Front": "What is a semaphore?", "Back": "A counter."},
+		},
+		{
+			// generated again from the overlapping page, worded slightly differently
+			{"Front": "what is a <b>mutex</b>", "Back": "A lock for threads.", sourcePageKey: "1"},
+			{"Front": "What is a monitor?", "Back": "A lock with conditions."},
+		},
+	}
+	got := mergeNotes("Basic", chunks)
+	var fronts []string
+	for _, note := range got {
+		fronts = append(fronts, note["Front"])
+	}
+	want := []string{"What is a mutex?", "What is a semaphore?", "What is a monitor?"}
+	if !reflect.DeepEqual(fronts, want) {
+		t.Errorf("mergeNotes fronts = %q, want %q", fronts, want)
+	}
+}
+
+func TestMergeNotesUnknownNoteType(t *testing.T) {
+	chunks := [][]map[string]string{
+		{{"Question": "Q", "Answer": "A", sourcePageKey: "1", sourceSectionKey: "Intro"}},
+		{{"Question": "Q", "Answer": "A", sourcePageKey: "2"}},
+		{{"Question": "Q", "Answer": "B"}},
+	}
+	if got := mergeNotes("No Such Type", chunks); len(got) != 2 {
+		t.Errorf("mergeNotes returned %d notes, want 2: %v", len(got), got)
+	}
+}
+
+func TestChunkingWithoutPoppler(t *testing.T) {
+	// only pdftotext is available, so the document cannot be split
+	fakePoppler(t)
+	stub := &stubLLM{notes: []map[string]string{{"Front": "What is a mutex?", "Back": "A lock."}}}
+	llm := NewChunkedLLM(stub, ChunkOptions{PagesPerChunk: 25})
+
+	_, err := llm.GenerateAnkiNotesProgress(context.Background(), strings.NewReader("%PDF-1.4"), "Basic", "1-3", nil)
+	if !errors.Is(err, exec.ErrNotFound) || !strings.Contains(err.Error(), "install poppler-utils") {
+		t.Errorf("selecting pages without poppler = %v, want a hint to install poppler-utils", err)
+	}
+
+	notes, err := llm.GenerateAnkiNotes(context.Background(), strings.NewReader("%PDF-1.4"), "Basic")
+	if err != nil || len(notes) != 1 || stub.calls != 1 {
+		t.Errorf("GenerateAnkiNotes = %v, %v after %d calls, want the PDF sent as a whole", notes, err, stub.calls)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// headlessOptions configures a non-interactive run started from the command line.
+type headlessOptions struct {
+	PDFPath   string
+	DeckName  string
+	NoteModel string
+	// Pages restricts generation to page ranges such as "45-78,90"; empty means all pages.
+	Pages string
+	// Similarity, if set, skips near-duplicates of existing notes and keeps only the first
+	// note of every cluster of generated near-duplicates.
+	Similarity *Similarity
+}
+
+// runHeadless generates notes from the configured PDF and adds them to the deck without
+// starting the TUI. A short summary is written to out; any failure is returned so the
+// caller can exit with a non-zero status.
+func runHeadless(ctx context.Context, out io.Writer, llm LLM, anki *Anki, opts headlessOptions) error {
+	f, err := os.Open(opts.PDFPath)
+	if err != nil {
+		return fmt.Errorf("failed to open pdf: %v", err)
+	}
+	defer f.Close()
+
+	var notes []map[string]string
+	if cl, ok := llm.(*ChunkedLLM); ok {
+		notes, err = cl.GenerateAnkiNotesProgress(ctx, f, opts.NoteModel, opts.Pages, func(done, total int) {
+			if total > 1 {
+				fmt.Fprintf(out, "generated chunk %d/%d\n", done, total)
+			}
+		})
+	} else if opts.Pages != "" {
+		return fmt.Errorf("page ranges require chunked generation")
+	} else {
+		notes, err = llm.GenerateAnkiNotes(ctx, f, opts.NoteModel)
+	}
+	if err != nil {
+		return err
+	}
+	notes, dropped := validNotes(opts.NoteModel, notes)
+	if dropped > 0 {
+		fmt.Fprintf(out, "dropping %d malformed %s notes\n", dropped, opts.NoteModel)
+	}
+	if len(notes) == 0 {
+		fmt.Fprintf(out, "no notes generated from %s\n", opts.PDFPath)
+		return nil
+	}
+
+	decks, err := anki.ListDeckNames(ctx)
+	if err != nil {
+		return err
+	}
+	// A new deck is created together with adding the notes, and has no duplicates.
+	newDeck := !slices.Contains(decks, opts.DeckName)
+	status := make([]string, len(notes))
+	var existing [][]string
+	if !newDeck {
+		if existing, err = anki.DeckNotes(ctx, opts.DeckName); err != nil {
+			return err
+		}
+		status, err = anki.CheckDuplicates(ctx, opts.DeckName, opts.NoteModel, notes, existing)
+		if err != nil {
+			return err
+		}
+	}
+	clusters := make([]int, len(notes))
+	similarTo := make([]string, len(notes))
+	if opts.Similarity != nil {
+		clusters, similarTo, err = opts.Similarity.FindSimilar(ctx, opts.NoteModel, notes, existing)
+		if err != nil {
+			return err
+		}
+	}
+
+	var fresh []map[string]string
+	var matches []string
+	dups, similar, clustered := 0, 0, 0
+	seen := map[int]bool{}
+	for i, note := range notes {
+		first := clusters[i] == 0 || !seen[clusters[i]]
+		seen[clusters[i]] = true
+		switch {
+		case status[i] != DuplicateNone:
+			dups++
+		case similarTo[i] != "":
+			similar++
+			matches = append(matches, fmt.Sprintf("  %q ~ %q", noteLabel(opts.NoteModel, note), similarTo[i]))
+		case !first:
+			clustered++
+		default:
+			fresh = append(fresh, note)
+		}
+	}
+	if dups > 0 {
+		fmt.Fprintf(out, "skipping %d notes already in deck %q\n", dups, opts.DeckName)
+	}
+	if similar > 0 {
+		fmt.Fprintf(out, "skipping %d notes similar to notes in deck %q:\n", similar, opts.DeckName)
+		for _, match := range matches {
+			fmt.Fprintln(out, match)
+		}
+	}
+	if clustered > 0 {
+		fmt.Fprintf(out, "skipping %d near-duplicates among the generated notes\n", clustered)
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	add := anki.AddNotes
+	if newDeck {
+		add = anki.CreateDeckAndAddNotes
+	}
+	ids, err := add(ctx, opts.DeckName, opts.NoteModel, fresh)
+	var addErr *AddNotesError
+	if errors.Is(err, ErrModelNotFound) {
+		return fmt.Errorf("%w; create it in Anki or choose another with -note-model", err)
+	}
+	if err != nil && !errors.As(err, &addErr) {
+		return err
+	}
+
+	added := 0
+	for _, id := range ids {
+		if id != 0 {
+			added++
+		}
+	}
+	if newDeck {
+		fmt.Fprintf(out, "created deck %q\n", opts.DeckName)
+	}
+	fmt.Fprintf(out, "added %d %s notes from %s to deck %q\n", added, opts.NoteModel, opts.PDFPath, opts.DeckName)
+	if addErr == nil {
+		return nil
+	}
+	// Notes added to the deck since the duplicate check are skipped like the others.
+	failed, dups := 0, 0
+	for i, err := range addErr.Errs {
+		switch {
+		case errors.Is(err, ErrDuplicateNote):
+			dups++
+		case err != nil:
+			failed++
+			fmt.Fprintf(out, "not added: %q: %v\n", noteLabel(opts.NoteModel, fresh[i]), err)
+		}
+	}
+	if dups > 0 {
+		fmt.Fprintf(out, "skipping %d notes already in deck %q\n", dups, opts.DeckName)
+	}
+	if failed == 0 {
+		return nil
+	}
+	return err
+}
+
+// noteLabel returns a short excerpt of the first field of a generated note for messages.
+func noteLabel(noteModel string, note map[string]string) string {
+	var label string
+	if nt, err := lookupNoteType(noteModel); err == nil {
+		label = mapFields(note, nt.Fields)[nt.Fields[0]]
+	}
+	if r := []rune(label); len(r) > 60 {
+		label = string(r[:57]) + "..."
+	}
+	return label
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRunHeadless(t *testing.T) {
+	pdf := filepath.Join(t.TempDir(), "lecture.pdf")
+	if err := os.WriteFile(pdf, []byte("%PDF-1.4 lecture"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fixture, err := readNotesFixture("testdata/llm/Basic.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fronts []string
+	for _, note := range fixture {
+		fronts = append(fronts, note["Front"])
+	}
+
+	tests := []struct {
+		name     string
+		decks    []string
+		existing []map[string]string
+		want     []string
+		output   []string
+	}{
+		{
+			name:   "new deck",
+			want:   fronts,
+			output: []string{"added 3 Basic notes"},
+		},
+		{
+			name:     "existing deck",
+			decks:    []string{"Lectures"},
+			existing: fixture[1:2],
+			want:     []string{fronts[1], fronts[0], fronts[2]},
+			output:   []string{`skipping 1 notes already in deck "Lectures"`, "added 2 Basic notes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, anki := newFakeAnkiConnect(t, "secret", tt.decks...)
+			if len(tt.existing) > 0 {
+				if _, err := anki.AddNotes(context.Background(), "Lectures", "Basic", tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var out strings.Builder
+			err := runHeadless(context.Background(), &out, NewFakeLLM("testdata/llm"), anki, headlessOptions{
+				PDFPath:   pdf,
+				DeckName:  "Lectures",
+				NoteModel: "Basic",
+			})
+			if err != nil {
+				t.Fatalf("runHeadless: %v", err)
+			}
+			if got := f.fronts("Lectures"); !slices.Equal(got, tt.want) {
+				t.Errorf("deck contains %q, want %q", got, tt.want)
+			}
+			for _, line := range tt.output {
+				if !strings.Contains(out.String(), line) {
+					t.Errorf("output %q does not contain %q", out.String(), line)
+				}
+			}
+		})
+	}
+}
+
+func TestRunHeadlessUnknownNoteType(t *testing.T) {
+	pdf := filepath.Join(t.TempDir(), "lecture.pdf")
+	if err := os.WriteFile(pdf, []byte("%PDF-1.4 lecture"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := writeNotesFixture(filepath.Join(dir, "Vocab.json"), []map[string]string{{"Word": "Haus"}}); err != nil {
+		t.Fatal(err)
+	}
+	_, anki := newFakeAnkiConnect(t, "", "Lectures")
+	err := runHeadless(context.Background(), &strings.Builder{}, NewFakeLLM(dir), anki, headlessOptions{
+		PDFPath:   pdf,
+		DeckName:  "Lectures",
+		NoteModel: "Vocab",
+	})
+	if !errors.Is(err, ErrModelNotFound) {
+		t.Errorf("runHeadless with a note type missing in Anki = %v, want %v", err, ErrModelNotFound)
+	}
+}
+
+func TestRunHeadlessReportsMalformedClozeNotes(t *testing.T) {
+	pdf := filepath.Join(t.TempDir(), "lecture.pdf")
+	if err := os.WriteFile(pdf, []byte("%PDF-1.4 lecture"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	notes := []map[string]string{
+		{"Text": "The capital of France is {{c1::Paris}}.", "Back Extra": ""},
+		{"Text": "The capital of Italy is Rome.", "Back Extra": ""},
+	}
+	if err := writeNotesFixture(filepath.Join(dir, "Cloze.json"), notes); err != nil {
+		t.Fatal(err)
+	}
+	f, anki := newFakeAnkiConnect(t, "", "Lectures")
+	var out strings.Builder
+	err := runHeadless(context.Background(), &out, NewFakeLLM(dir), anki, headlessOptions{
+		PDFPath:   pdf,
+		DeckName:  "Lectures",
+		NoteModel: "Cloze",
+	})
+	if err != nil {
+		t.Fatalf("runHeadless: %v", err)
+	}
+	if got := f.fronts("Lectures"); !slices.Equal(got, []string{notes[0]["Text"]}) {
+		t.Errorf("deck contains %q, want only the well-formed note", got)
+	}
+	if !strings.Contains(out.String(), "dropping 1 malformed Cloze notes") {
+		t.Errorf("output %q does not report the malformed note", out.String())
+	}
+}
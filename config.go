@@ -0,0 +1,71 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultAnkiURL is the endpoint AnkiConnect listens on unless configured otherwise.
+const defaultAnkiURL = "http://localhost:8765"
+
+// fileConfig is the content of the config file, e.g.
+//
+//	{"anki_url": "http://192.168.1.20:8765", "anki_api_key": "secret"}
+type fileConfig struct {
+	AnkiURL    string `json:"anki_url"`
+	AnkiAPIKey string `json:"anki_api_key"`
+}
+
+// ankiConfig holds the resolved AnkiConnect endpoint and API key.
+type ankiConfig struct {
+	URL    string
+	APIKey string
+}
+
+// defaultConfigPath returns the path of the config file used when -config is not given.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "anki-llm", "config.json")
+}
+
+// readConfig reads the config file at path. A missing file is only an error if required.
+func readConfig(path string, required bool) (fileConfig, error) {
+	var cfg fileConfig
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// resolveAnkiConfig picks the AnkiConnect endpoint and API key from the flags, the
+// environment variables ANKICONNECT_URL and ANKICONNECT_API_KEY read through lookup, and
+// the config file, in this order of precedence. Endpoints without a scheme use http.
+func resolveAnkiConfig(flagURL, flagKey string, lookup func(string) string, file fileConfig) ankiConfig {
+	cfg := ankiConfig{
+		URL:    cmp.Or(flagURL, lookup("ANKICONNECT_URL"), file.AnkiURL, defaultAnkiURL),
+		APIKey: cmp.Or(flagKey, lookup("ANKICONNECT_API_KEY"), file.AnkiAPIKey),
+	}
+	if !strings.Contains(cfg.URL, "://") {
+		cfg.URL = "http://" + cfg.URL
+	}
+	return cfg
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestResolveAnkiConfig(t *testing.T) {
+	env := map[string]string{"ANKICONNECT_URL": "http://env:8765", "ANKICONNECT_API_KEY": "env-key"}
+	file := fileConfig{AnkiURL: "http://file:8765", AnkiAPIKey: "file-key"}
+	tests := []struct {
+		name             string
+		flagURL, flagKey string
+		env              map[string]string
+		file             fileConfig
+		want             ankiConfig
+	}{
+		{name: "defaults", want: ankiConfig{URL: defaultAnkiURL}},
+		{name: "file", file: file, want: ankiConfig{URL: "http://file:8765", APIKey: "file-key"}},
+		{name: "env over file", env: env, file: file, want: ankiConfig{URL: "http://env:8765", APIKey: "env-key"}},
+		{
+			name: "flags over env", flagURL: "http://flag:8765", flagKey: "flag-key", env: env, file: file,
+			want: ankiConfig{URL: "http://flag:8765", APIKey: "flag-key"},
+		},
+		{name: "mixed", flagKey: "flag-key", file: file, want: ankiConfig{URL: "http://file:8765", APIKey: "flag-key"}},
+		{name: "no scheme", flagURL: "192.168.1.20:8765", want: ankiConfig{URL: "http://192.168.1.20:8765"}},
+	}
+	for _, tt := range tests {
+		lookup := func(key string) string { return tt.env[key] }
+		if got := resolveAnkiConfig(tt.flagURL, tt.flagKey, lookup, tt.file); got != tt.want {
+			t.Errorf("%s: resolveAnkiConfig = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
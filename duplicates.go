@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Duplicate states reported by CheckDuplicates for every note.
+const (
+	// DuplicateNone means the note can be added.
+	DuplicateNone = ""
+	// DuplicateExact means Anki rejects the note as a duplicate in the deck.
+	DuplicateExact = "duplicate"
+	// DuplicateSimilar means a note in the deck has the same first field once formatting,
+	// case and punctuation are ignored.
+	DuplicateSimilar = "similar"
+)
+
+// NoteInfo is an existing note as returned by AnkiConnect's notesInfo.
+type NoteInfo struct {
+	NoteID    int64                `json:"noteId"`
+	ModelName string               `json:"modelName"`
+	Tags      []string             `json:"tags"`
+	Fields    map[string]NoteField `json:"fields"`
+}
+
+// NoteField is the value of a field of an existing note and its position in the note type.
+type NoteField struct {
+	Value string `json:"value"`
+	Order int    `json:"order"`
+}
+
+// Values returns the field values of the note in the order of the fields of its note type.
+func (n NoteInfo) Values() []string {
+	values := make([]string, len(n.Fields))
+	for _, f := range n.Fields {
+		if f.Order >= 0 && f.Order < len(values) {
+			values[f.Order] = f.Value
+		}
+	}
+	return values
+}
+
+// CheckDuplicates reports for every note whether it duplicates a note in the deck, using
+// canAddNotes for exact duplicates and a comparison of the normalized first fields of the
+// existing notes of the deck, as returned by DeckNotes, for near-duplicates. The result
+// has one DuplicateNone, DuplicateExact or DuplicateSimilar entry per note.
+func (a *Anki) CheckDuplicates(ctx context.Context, deckName, modelName string, notes []map[string]string, existing [][]string) ([]string, error) {
+	fields, err := a.ModelFieldNames(ctx, modelName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check duplicates: %w", err)
+	}
+
+	noteData := make([]Note, len(notes))
+	for i, note := range notes {
+		noteData[i] = a.toNote(deckName, modelName, fields, note)
+	}
+	result, err := a.invoke(ctx, "canAddNotes", map[string]interface{}{"notes": noteData})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check duplicates: %w", err)
+	}
+	var canAdd []bool
+	if err := decodeResult(result, &canAdd); err != nil {
+		return nil, fmt.Errorf("failed to check duplicates: %w", err)
+	}
+	if len(canAdd) != len(notes) {
+		return nil, fmt.Errorf("failed to check duplicates: got %d results for %d notes", len(canAdd), len(notes))
+	}
+	known := make(map[string]bool, len(existing))
+	for _, values := range existing {
+		if len(values) == 0 {
+			continue
+		}
+		if key := normalizeText(values[0]); key != "" {
+			known[key] = true
+		}
+	}
+
+	status := make([]string, len(notes))
+	for i, n := range noteData {
+		switch {
+		case !canAdd[i] && n.Fields[fields[0]] != "":
+			status[i] = DuplicateExact
+		case known[normalizeText(n.Fields[fields[0]])]:
+			status[i] = DuplicateSimilar
+		}
+	}
+	return status, nil
+}
+
+// FindNotes returns the IDs of all notes matching the Anki search query.
+func (a *Anki) FindNotes(ctx context.Context, query string) ([]int64, error) {
+	result, err := a.invoke(ctx, "findNotes", map[string]string{"query": query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find notes: %w", err)
+	}
+	var ids []int64
+	if err := decodeResult(result, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// NotesInfo returns the fields and tags of the notes with the given IDs.
+func (a *Anki) NotesInfo(ctx context.Context, ids []int64) ([]NoteInfo, error) {
+	result, err := a.invoke(ctx, "notesInfo", map[string]interface{}{"notes": ids})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notes info: %w", err)
+	}
+	var infos []NoteInfo
+	if err := decodeResult(result, &infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// DeckNotes returns the field values of all notes in the deck and its subdecks, each in
+// the order of the fields of the note's type. The result is meant to be fetched once and
+// shared by CheckDuplicates and Similarity.FindSimilar.
+func (a *Anki) DeckNotes(ctx context.Context, deckName string) ([][]string, error) {
+	ids, err := a.FindNotes(ctx, deckQuery(deckName))
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	infos, err := a.NotesInfo(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	notes := make([][]string, len(infos))
+	for i, info := range infos {
+		notes[i] = info.Values()
+	}
+	return notes, nil
+}
+
+// deckQuery returns an Anki search query matching the notes of the deck.
+func deckQuery(deckName string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `*`, `\*`, `_`, `\_`).Replace(deckName)
+	return `"deck:` + escaped + `"`
+}
+
+var (
+	htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+	nonWordPattern = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+)
+
+// normalizeText reduces a field value to lower-case words so that formatting, case and
+// punctuation differences do not hide duplicates.
+func normalizeText(s string) string {
+	s = html.UnescapeString(htmlTagPattern.ReplaceAllString(s, " "))
+	return strings.TrimSpace(nonWordPattern.ReplaceAllString(strings.ToLower(s), " "))
+}
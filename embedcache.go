@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CachedEmbedder remembers the embeddings of the wrapped Embedder by text and stores them
+// in a file, so the notes of a deck are only embedded once instead of on every run. It must
+// only wrap embedders whose vectors do not depend on the other texts of a call, which rules
+// out TFIDFEmbedder.
+type CachedEmbedder struct {
+	embedder Embedder
+	path     string
+
+	mu     sync.Mutex
+	loaded bool
+	cache  map[string][]float32
+}
+
+// NewCachedEmbedder wraps embedder with a cache stored at path. An empty path keeps the
+// cache in memory only.
+func NewCachedEmbedder(embedder Embedder, path string) *CachedEmbedder {
+	return &CachedEmbedder{embedder: embedder, path: path, cache: map[string][]float32{}}
+}
+
+// embeddingCachePath returns the path of the cache file of the given embedding model, or an
+// empty string if there is no user cache directory.
+func embeddingCachePath(embedder Embedder, model string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%T %s", embedder, model)))
+	return filepath.Join(dir, "anki-llm", "embeddings", hex.EncodeToString(sum[:8])+".gob")
+}
+
+// Embed returns the cached embeddings of texts and embeds only the texts not seen before.
+func (c *CachedEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loaded {
+		// The cache is only an optimization; an unreadable file is rebuilt.
+		if err := c.load(); err != nil {
+			c.cache = map[string][]float32{}
+		}
+		c.loaded = true
+	}
+
+	keys := make([]string, len(texts))
+	var missing, missingKeys []string
+	queued := map[string]bool{}
+	for i, text := range texts {
+		sum := sha256.Sum256([]byte(text))
+		keys[i] = hex.EncodeToString(sum[:])
+		if _, ok := c.cache[keys[i]]; !ok && !queued[keys[i]] {
+			queued[keys[i]] = true
+			missing = append(missing, text)
+			missingKeys = append(missingKeys, keys[i])
+		}
+	}
+
+	if len(missing) > 0 {
+		vectors, err := c.embedder.Embed(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		if len(vectors) != len(missing) {
+			return nil, fmt.Errorf("got %d embeddings for %d texts", len(vectors), len(missing))
+		}
+		for i, key := range missingKeys {
+			c.cache[key] = vectors[i]
+		}
+		_ = c.save()
+	}
+
+	vectors := make([][]float32, len(texts))
+	for i, key := range keys {
+		vectors[i] = c.cache[key]
+	}
+	return vectors, nil
+}
+
+func (c *CachedEmbedder) load() error {
+	if c.path == "" {
+		return nil
+	}
+	f, err := os.Open(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gob.NewDecoder(f).Decode(&c.cache)
+}
+
+// save writes the cache to a temporary file first, so an interrupted write does not leave
+// a corrupt cache behind.
+func (c *CachedEmbedder) save() error {
+	if c.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), "embeddings-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(c.cache); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// countingEmbedder embeds a text as its length and records the texts it was asked for.
+type countingEmbedder struct {
+	texts []string
+}
+
+func (e *countingEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	e.texts = append(e.texts, texts...)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = []float32{float32(len(text))}
+	}
+	return vectors, nil
+}
+
+func TestCachedEmbedder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "embeddings.gob")
+	embedder := &countingEmbedder{}
+	ctx := context.Background()
+
+	got, err := NewCachedEmbedder(embedder, path).Embed(ctx, []string{"a", "bb", "a"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if want := [][]float32{{1}, {2}, {1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Embed = %v, want %v", got, want)
+	}
+
+	// a new run reads the cache from the file and only embeds the new text
+	got, err = NewCachedEmbedder(embedder, path).Embed(ctx, []string{"bb", "ccc"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if want := [][]float32{{2}, {3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Embed = %v, want %v", got, want)
+	}
+	if want := []string{"a", "bb", "ccc"}; !reflect.DeepEqual(embedder.texts, want) {
+		t.Errorf("embedded %q, want %q", embedder.texts, want)
+	}
+}
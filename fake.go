@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+)
+
+// FakeLLM is a deterministic LLM that answers from fixture files instead of calling a
+// model. For every request it first looks for a recording named after the input key
+// (see inputKey) and falls back to <noteModel>.json, both inside dir.
+type FakeLLM struct {
+	dir string
+}
+
+func init() {
+	registerProvider("fake", provider{
+		Defaults: map[string]string{"FAKE_LLM_FIXTURES": "testdata/llm"},
+		New: func(_ context.Context, cfg providerConfig) (LLM, error) {
+			return NewFakeLLM(cfg["FAKE_LLM_FIXTURES"]), nil
+		},
+	})
+}
+
+// NewFakeLLM creates a FakeLLM reading its fixtures from dir.
+func NewFakeLLM(dir string) *FakeLLM {
+	return &FakeLLM{dir: dir}
+}
+
+func (f *FakeLLM) GenerateAnkiNotes(_ context.Context, r io.Reader, noteModel string) ([]map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %v", err)
+	}
+
+	for _, name := range []string{inputKey(data, noteModel), noteModel} {
+		notes, err := readNotesFixture(filepath.Join(f.dir, name+".json"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return notes, err
+	}
+	return nil, fmt.Errorf("no fixture for note model %q in %s", noteModel, f.dir)
+}
+
+// RefineNote answers from the recording of the refinement request, if there is one, and
+// returns the note unchanged otherwise.
+func (f *FakeLLM) RefineNote(_ context.Context, noteModel string, note map[string]string, instruction string) ([]map[string]string, error) {
+	_, input, err := refineRequest(noteModel, note, instruction)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := readNotesFixture(filepath.Join(f.dir, inputKey([]byte(input), noteModel)+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []map[string]string{maps.Clone(note)}, nil
+	}
+	return notes, err
+}
+
+func (f *FakeLLM) Close() error {
+	return nil
+}
+
+// RecordingLLM wraps another LLM and writes every successful response to dir, named
+// after the input key, so a later run with FakeLLM on the same directory replays it.
+type RecordingLLM struct {
+	llm LLM
+	dir string
+}
+
+// NewRecordingLLM creates a RecordingLLM that records the responses of llm into dir.
+func NewRecordingLLM(llm LLM, dir string) *RecordingLLM {
+	return &RecordingLLM{llm: llm, dir: dir}
+}
+
+func (rec *RecordingLLM) GenerateAnkiNotes(ctx context.Context, r io.Reader, noteModel string) ([]map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %v", err)
+	}
+
+	notes, err := rec.llm.GenerateAnkiNotes(ctx, bytes.NewReader(data), noteModel)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeNotesFixture(filepath.Join(rec.dir, inputKey(data, noteModel)+".json"), notes); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+func (rec *RecordingLLM) RefineNote(ctx context.Context, noteModel string, note map[string]string, instruction string) ([]map[string]string, error) {
+	refiner, ok := rec.llm.(Refiner)
+	if !ok {
+		return nil, fmt.Errorf("refining notes is not supported by this LLM")
+	}
+	_, input, err := refineRequest(noteModel, note, instruction)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := refiner.RefineNote(ctx, noteModel, note, instruction)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeNotesFixture(filepath.Join(rec.dir, inputKey([]byte(input), noteModel)+".json"), notes); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+func (rec *RecordingLLM) Close() error {
+	return rec.llm.Close()
+}
+
+// inputKey identifies a generation request by the hash of its note model and input.
+func inputKey(data []byte, noteModel string) string {
+	h := sha256.New()
+	h.Write([]byte(noteModel))
+	h.Write([]byte{0})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func readNotesFixture(path string) ([]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var notes []map[string]string
+	if err := json.Unmarshal(data, &notes); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture %s: %v", path, err)
+	}
+	return notes, nil
+}
+
+func writeNotesFixture(path string, notes []map[string]string) error {
+	data, err := json.MarshalIndent(notes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal recording: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create recording directory: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write recording: %v", err)
+	}
+	return nil
+}
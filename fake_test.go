@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFakeLLMReplaysByInputKey(t *testing.T) {
+	dir := t.TempDir()
+	fallback := []map[string]string{{"Front": "Fallback", "Back": "Any input"}}
+	recorded := []map[string]string{{"Front": "Recorded", "Back": "This input"}}
+	if err := writeNotesFixture(filepath.Join(dir, "Basic.json"), fallback); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeNotesFixture(filepath.Join(dir, inputKey([]byte("lecture 1"), "Basic")+".json"), recorded); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := NewFakeLLM(dir)
+	tests := []struct {
+		input, noteModel string
+		want             []map[string]string
+	}{
+		{input: "lecture 1", noteModel: "Basic", want: recorded},
+		{input: "lecture 2", noteModel: "Basic", want: fallback},
+	}
+	for _, tt := range tests {
+		got, err := fake.GenerateAnkiNotes(context.Background(), strings.NewReader(tt.input), tt.noteModel)
+		if err != nil {
+			t.Errorf("GenerateAnkiNotes(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GenerateAnkiNotes(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+
+	if _, err := fake.GenerateAnkiNotes(context.Background(), strings.NewReader("lecture 1"), "Cloze"); err == nil {
+		t.Error("GenerateAnkiNotes without a fixture for the note model succeeded")
+	}
+}
+
+func TestInputKey(t *testing.T) {
+	key := inputKey([]byte("pdf"), "Basic")
+	if key != inputKey([]byte("pdf"), "Basic") {
+		t.Error("inputKey is not deterministic")
+	}
+	if key == inputKey([]byte("pdf"), "Cloze") || key == inputKey([]byte("other"), "Basic") {
+		t.Error("inputKey does not depend on the note model and input")
+	}
+	// the separator keeps the note model from running into the input
+	if inputKey([]byte("cBasic"), "Clo") == inputKey([]byte("Basic"), "Cloc") {
+		t.Error("inputKey is ambiguous")
+	}
+}
+
+// stubLLM returns fixed notes or a fixed error and counts its calls.
+type stubLLM struct {
+	notes []map[string]string
+	err   error
+	calls int
+}
+
+func (s *stubLLM) GenerateAnkiNotes(context.Context, io.Reader, string) ([]map[string]string, error) {
+	s.calls++
+	return s.notes, s.err
+}
+
+func (s *stubLLM) RefineNote(_ context.Context, _ string, note map[string]string, instruction string) ([]map[string]string, error) {
+	s.calls++
+	return []map[string]string{{"Front": note["Front"] + " (" + instruction + ")", "Back": note["Back"]}}, nil
+}
+
+func (s *stubLLM) Close() error {
+	return nil
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	stub := &stubLLM{notes: []map[string]string{{"Front": "What is a mutex?", "Back": "A lock."}}}
+	rec := NewRecordingLLM(stub, dir)
+	ctx := context.Background()
+
+	generated, err := rec.GenerateAnkiNotes(ctx, strings.NewReader("lecture"), "Basic")
+	if err != nil {
+		t.Fatalf("GenerateAnkiNotes: %v", err)
+	}
+	note := generated[0]
+	refined, err := rec.RefineNote(ctx, "Basic", note, "shorter")
+	if err != nil {
+		t.Fatalf("RefineNote: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("recorded %d files, want 2", len(entries))
+	}
+
+	fake := NewFakeLLM(dir)
+	replayed, err := fake.GenerateAnkiNotes(ctx, strings.NewReader("lecture"), "Basic")
+	if err != nil {
+		t.Fatalf("replaying GenerateAnkiNotes: %v", err)
+	}
+	if !reflect.DeepEqual(replayed, generated) {
+		t.Errorf("replayed notes %v, want %v", replayed, generated)
+	}
+	replayedRefined, err := fake.RefineNote(ctx, "Basic", note, "shorter")
+	if err != nil {
+		t.Fatalf("replaying RefineNote: %v", err)
+	}
+	if !reflect.DeepEqual(replayedRefined, refined) {
+		t.Errorf("replayed refinement %v, want %v", replayedRefined, refined)
+	}
+	if stub.calls != 2 {
+		t.Errorf("wrapped LLM called %d times, want 2", stub.calls)
+	}
+
+	// an unrecorded refinement leaves the note unchanged
+	unchanged, err := fake.RefineNote(ctx, "Basic", note, "longer")
+	if err != nil || !reflect.DeepEqual(unchanged, []map[string]string{note}) {
+		t.Errorf("RefineNote without recording = %v, %v, want the note unchanged", unchanged, err)
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/option"
 	"io"
-	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var prompt = `
@@ -52,6 +55,54 @@ Your output should be formatted as:
 
 Generate concise, clear, and focused notes designed for effective learning.`
 
+var clozePrompt = `
+You are an intelligent assistant designed to generate Anki cloze deletion notes from PDF documents. Your goal is to extract key information from the PDF and turn it into sentences with the important terms hidden.
+
+Output Requirements:
+- Each note must have a "Text" and a "Back Extra".
+- The "Text" is a self-contained statement in which key terms are wrapped in cloze deletions of the form {{c1::hidden text}}.
+- A hint may be added after a second "::", e.g. {{c1::mitochondria::organelle}}.
+- Every "Text" must contain at least one cloze deletion.
+- Use several deletions per note when a statement has multiple facts worth recalling. Number them {{c1::...}}, {{c2::...}}, ... to create one card per number, or reuse the same number to hide several terms on the same card.
+- The "Back Extra" may contain a short clarification or example that is shown after answering. Leave it empty if nothing useful can be added.
+
+Example:
+- "Text": "{{c1::Photosynthesis}} converts {{c2::light energy}} into {{c2::chemical energy}} stored in glucose."
+- "Back Extra": "Takes place in the chloroplasts of plant cells."
+
+Guidelines:
+1. Focus on sections titled "Key Concepts," "Summary," or bolded/highlighted content. Ignore references, footnotes, or content unlikely to appear on a flashcard.
+2. Hide only the words that are worth memorizing; the remaining sentence must give enough context to recall them.
+3. Keep each "Text" below 40 words.
+4. Generate notes in the SAME language as the source PDF, even if some parts of the PDF are in a different language.
+
+Using LaTeX:
+- ALWAYS use LaTeX for mathematical formulas, scientific notations and Greek symbols. Use \\( and \\) for inline and \\[ and \\] for block formulas.
+- A formula can be hidden as a whole, e.g. "The area of a circle is {{c1::\\( A = \\pi r^2 \\)}}."
+- Never write "}}" inside a cloze deletion, as it ends the deletion early. Separate closing LaTeX braces with a space, e.g. "\\frac{a}{b} }".
+
+Your output should be formatted as:
+- "Text": <statement with cloze deletions>
+- "Back Extra": <optional extra information>
+
+Generate concise, clear, and focused notes designed for effective learning.`
+
+var genericPrompt = `
+You are an intelligent assistant designed to generate Anki notes from PDF documents. Your goal is to extract key information from the PDF and format it into effective Anki flashcards.
+
+Guidelines:
+1. Focus on sections titled "Key Concepts," "Summary," or bolded/highlighted content. Ignore references, footnotes, or content unlikely to appear on a flashcard.
+2. Keep every field concise, accurate and focused on a single fact.
+3. Generate notes in the SAME language as the source PDF, even if some parts of the PDF are in a different language.
+4. ALWAYS use LaTeX for mathematical formulas, scientific notations and Greek symbols. Use \\( and \\) for inline and \\[ and \\] for block formulas.
+5. Leave a field empty if the PDF provides no sensible content for it.`
+
+// prompts holds the dedicated system prompts of the built-in note models.
+var prompts = map[string]string{
+	"Basic": prompt,
+	"Cloze": clozePrompt,
+}
+
 type LLM interface {
 	// GenerateAnkiNotes generates Anki notes from the given reader.
 	// The reader is expected to contain the content to be converted to Anki notes.
@@ -61,8 +112,28 @@ type LLM interface {
 }
 
 type GeminiLLM struct {
-	client *genai.Client
-	model  *genai.GenerativeModel
+	client         *genai.Client
+	model          *genai.GenerativeModel
+	embeddingModel string
+}
+
+func init() {
+	registerProvider("gemini", provider{
+		Required: []string{"GEMINI_API_KEY"},
+		Defaults: map[string]string{
+			"GEMINI_MODEL":           "gemini-3-flash-preview",
+			"GEMINI_EMBEDDING_MODEL": "text-embedding-004",
+		},
+		ModelKey: "GEMINI_MODEL",
+		New: func(ctx context.Context, cfg providerConfig) (LLM, error) {
+			llm, err := NewGeminiLLM(ctx, cfg["GEMINI_MODEL"], cfg["GEMINI_API_KEY"])
+			if err != nil {
+				return nil, err
+			}
+			llm.(*GeminiLLM).embeddingModel = cfg["GEMINI_EMBEDDING_MODEL"]
+			return llm, nil
+		},
+	})
 }
 
 func NewGeminiLLM(ctx context.Context, model string, apiKey string) (LLM, error) {
@@ -76,52 +147,134 @@ func NewGeminiLLM(ctx context.Context, model string, apiKey string) (LLM, error)
 	return &GeminiLLM{client: cli, model: m}, nil
 }
 
-var schemas = map[string]genai.Schema{
-	"Basic": {
-		Type: genai.TypeArray,
-		Items: &genai.Schema{
-			Type: genai.TypeObject,
-			Properties: map[string]*genai.Schema{
-				"Front": {Type: genai.TypeString},
-				"Back":  {Type: genai.TypeString},
-			},
-			Required: []string{"Front", "Back"},
-		},
-	},
+// clozePattern matches a single cloze deletion such as {{c1::answer}} or {{c2::answer::hint}}.
+var clozePattern = regexp.MustCompile(`\{\{c(\d+)::(.*?)(?:::(.*?))?\}\}`)
+
+// validNotes returns the generated notes that are usable for noteModel and the number of
+// notes it dropped. Notes of a cloze note type without a well-formed cloze deletion are
+// dropped, so a single bad note does not fail the whole generation.
+func validNotes(noteModel string, notes []map[string]string) ([]map[string]string, int) {
+	nt, err := lookupNoteType(noteModel)
+	if err != nil || !nt.Cloze {
+		return notes, 0
+	}
+	valid := make([]map[string]string, 0, len(notes))
+	for _, note := range notes {
+		if validateCloze(note[nt.Fields[0]]) == nil {
+			valid = append(valid, note)
+		}
+	}
+	return valid, len(notes) - len(valid)
+}
+
+// validateCloze reports whether text contains at least one cloze deletion and no
+// malformed ones, e.g. unterminated deletions or deletions numbered c0.
+func validateCloze(text string) error {
+	matches := clozePattern.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return fmt.Errorf("no cloze deletion in %q", text)
+	}
+	if opened := strings.Count(text, "{{c"); opened != len(matches) {
+		return fmt.Errorf("malformed cloze deletion in %q", text)
+	}
+	for _, m := range matches {
+		if n, _ := strconv.Atoi(m[1]); n < 1 {
+			return fmt.Errorf("cloze number must start at 1 in %q", text)
+		}
+		if strings.TrimSpace(m[2]) == "" {
+			return fmt.Errorf("empty cloze deletion in %q", text)
+		}
+	}
+	return nil
 }
 
 func (g *GeminiLLM) GenerateAnkiNotes(ctx context.Context, r io.Reader, noteModel string) ([]map[string]string, error) {
 	file, err := g.client.UploadFile(ctx, "", r, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to upload file: %v", err)
+		return nil, fmt.Errorf("failed to upload file: %w", err)
 	}
-	defer g.client.DeleteFile(ctx, file.Name)
+	defer func() {
+		// also delete the file when the generation has been cancelled
+		dctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		g.client.DeleteFile(dctx, file.Name)
+	}()
 
-	schema, ok := schemas[noteModel]
-	if !ok {
-		return nil, fmt.Errorf("note model %q not found", noteModel)
+	instructions, err := promptFor(noteModel)
+	if err != nil {
+		return nil, err
 	}
-	g.model.ResponseSchema = &schema
+	return g.generate(ctx, noteModel, genai.Text(instructions), genai.FileData{URI: file.URI})
+}
 
-	resp, err := g.model.GenerateContent(ctx,
-		genai.Text(prompt),
-		genai.FileData{URI: file.URI},
-	)
+// RefineNote rewrites a single note following instruction.
+func (g *GeminiLLM) RefineNote(ctx context.Context, noteModel string, note map[string]string, instruction string) ([]map[string]string, error) {
+	instructions, input, err := refineRequest(noteModel, note, instruction)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate anki card content: %v", err)
+		return nil, err
+	}
+	notes, err := g.generate(ctx, noteModel, genai.Text(instructions), genai.Text(input))
+	if err != nil {
+		return nil, err
+	}
+	return finishRefined(noteModel, note, notes)
+}
+
+// generate sends parts to the model and parses the response as notes of noteModel.
+func (g *GeminiLLM) generate(ctx context.Context, noteModel string, parts ...genai.Part) ([]map[string]string, error) {
+	schema, err := schemaFor(noteModel)
+	if err != nil {
+		return nil, err
+	}
+	// Copy the model so concurrent generations do not share the response schema.
+	model := *g.model
+	model.ResponseSchema = schema
+
+	resp, err := model.GenerateContent(ctx, parts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate anki card content: %w", err)
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return nil, fmt.Errorf("failed to generate anki card content: empty response")
 	}
 
 	var notes []map[string]string
 	for _, part := range resp.Candidates[0].Content.Parts {
 		if txt, ok := part.(genai.Text); ok {
 			if err := json.Unmarshal([]byte(txt), &notes); err != nil {
-				log.Fatal(err)
+				return nil, fmt.Errorf("failed to parse generated notes: %v", err)
 			}
 		}
 	}
 	return notes, nil
 }
 
+// Embed embeds texts with the configured Gemini embedding model, in batches of
+// embedBatchSize texts.
+func (g *GeminiLLM) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	em := g.client.EmbeddingModel(g.embeddingModel)
+	return embedInBatches(ctx, texts, func(ctx context.Context, texts []string) ([][]float32, error) {
+		batch := em.NewBatch()
+		for _, text := range texts {
+			batch.AddContent(genai.Text(text))
+		}
+		resp, err := em.BatchEmbedContents(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		vectors := make([][]float32, len(resp.Embeddings))
+		for i, e := range resp.Embeddings {
+			vectors[i] = e.Values
+		}
+		return vectors, nil
+	})
+}
+
+// EmbeddingModel returns the name of the model used by Embed.
+func (g *GeminiLLM) EmbeddingModel() string {
+	return g.embeddingModel
+}
+
 func (g *GeminiLLM) Close() error {
 	return g.client.Close()
 }
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestValidateCloze(t *testing.T) {
+	tests := []struct {
+		text  string
+		valid bool
+	}{
+		{text: "The capital of France is {{c1::Paris}}.", valid: true},
+		{text: "{{c1::Go}} was released in {{c2::2009::year}}.", valid: true},
+		{text: "No deletion here.", valid: false},
+		{text: "Unterminated {{c1::deletion.", valid: false},
+		{text: "Numbered {{c0::zero}}.", valid: false},
+		{text: "Empty {{c1:: }} deletion.", valid: false},
+	}
+	for _, tt := range tests {
+		if err := validateCloze(tt.text); (err == nil) != tt.valid {
+			t.Errorf("validateCloze(%q) = %v, want valid %v", tt.text, err, tt.valid)
+		}
+	}
+}
+
+func TestValidNotes(t *testing.T) {
+	notes := []map[string]string{
+		{"Text": "The capital of France is {{c1::Paris}}."},
+		{"Text": "No deletion here."},
+		{"Text": "Unterminated {{c1::deletion."},
+	}
+	valid, dropped := validNotes("Cloze", notes)
+	if len(valid) != 1 || valid[0]["Text"] != notes[0]["Text"] || dropped != 2 {
+		t.Errorf("validNotes(Cloze) = %v, %d dropped, want the first note and 2 dropped", valid, dropped)
+	}
+	if valid, dropped := validNotes("Basic", notes); len(valid) != len(notes) || dropped != 0 {
+		t.Errorf("validNotes(Basic) = %v, %d dropped, want every note", valid, dropped)
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"flag"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/joho/godotenv"
@@ -15,16 +19,92 @@ import (
 func main() {
 	_ = godotenv.Load()
 
+	pdfPath := flag.String("pdf", "", "PDF file to generate notes from; runs without the TUI when set")
+	pages := flag.String("pages", "", "only generate notes for these pages, e.g. 45-78,90")
+	deckName := flag.String("deck", "Default", "Anki deck the generated notes are added to")
+	noteModel := flag.String("note-model", "Basic", "Anki note type of the generated notes")
+	model := flag.String("model", "", "LLM model name; defaults to the provider's model variable")
+	configPath := flag.String("config", "", "config file; defaults to anki-llm/config.json in the user config directory")
+	ankiURL := flag.String("anki-url", "", "AnkiConnect endpoint; overrides ANKICONNECT_URL and the config file (default "+defaultAnkiURL+")")
+	ankiKey := flag.String("anki-key", "", "API key of AnkiConnect, if it requires one; overrides ANKICONNECT_API_KEY and the config file")
+	ankiTimeout := flag.Duration("anki-timeout", 30*time.Second, "timeout for a single AnkiConnect request")
+	sourceField := flag.String("source-field", "", "note field that receives the source file, page and section")
+	sourceTags := flag.Bool("source-tags", true, "tag notes with their source, e.g. source::lecture03::p12")
+	chunkPages := flag.Int("chunk-pages", 25, "pages per LLM request for large PDFs; 0 sends the whole PDF at once")
+	chunkOverlap := flag.Int("chunk-overlap", 0, "pages shared by consecutive chunks")
+	parallel := flag.Int("parallel", 4, "maximum number of chunks generated concurrently")
+	chunkTimeout := flag.Duration("chunk-timeout", 3*time.Minute, "timeout for generating a single chunk")
+	embeddings := flag.String("embeddings", "off", "near-duplicate detection: off, local (offline TF-IDF) or provider (the LLM provider's embeddings)")
+	similarityThreshold := flag.Float64("similarity-threshold", 0, "cosine similarity from which notes count as near-duplicates; 0 picks a default for -embeddings")
+	flag.Parse()
+
+	config, err := readConfig(cmp.Or(*configPath, defaultConfigPath()), *configPath != "")
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	ankiCfg := resolveAnkiConfig(*ankiURL, *ankiKey, os.Getenv, config)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go handleSignals(cancel)
 
-	llm := initializeLLM(ctx, os.Getenv("GEMINI_MODEL"))
+	provider := initializeLLM(ctx, *model)
+	llm := NewChunkedLLM(provider, ChunkOptions{
+		PagesPerChunk: *chunkPages,
+		Overlap:       *chunkOverlap,
+		Parallelism:   *parallel,
+		Timeout:       *chunkTimeout,
+	})
 	defer llm.Close()
-	anki := initializeAnkiClient()
+	anki := initializeAnkiClient(ankiCfg.URL,
+		WithHTTPClient(&http.Client{Timeout: *ankiTimeout}),
+		WithAPIKey(ankiCfg.APIKey),
+		WithSourceField(*sourceField),
+		WithSourceTags(*sourceTags),
+	)
+	connErr := anki.Handshake(ctx)
+	if connErr == nil {
+		if err := loadNoteTypes(ctx, anki); err != nil {
+			log.Printf("could not load note types from Anki, using built-in ones: %v", err)
+		}
+	}
+	similarity := initializeSimilarity(*embeddings, *similarityThreshold, provider)
+
+	if *pdfPath != "" {
+		if connErr != nil {
+			llm.Close()
+			log.Fatalf("failed to connect to Anki: %v", connErr)
+		}
+		err := runHeadless(ctx, os.Stdout, llm, anki, headlessOptions{
+			PDFPath:    *pdfPath,
+			DeckName:   *deckName,
+			NoteModel:  *noteModel,
+			Pages:      *pages,
+			Similarity: similarity,
+		})
+		if err != nil {
+			llm.Close()
+			log.Fatalf("failed to generate notes: %v", err)
+		}
+		return
+	}
 
 	// Create and start the TUI program
-	uiModel := ui.NewModel(ctx, llm, anki)
+	opts := []ui.Option{
+		ui.WithConnection(connErr),
+		ui.WithNoteTypeLoader(func(ctx context.Context) error {
+			return loadNoteTypes(ctx, anki)
+		}),
+		ui.WithClozeModels(func(name string) bool {
+			nt, err := lookupNoteType(name)
+			return err == nil && nt.Cloze
+		}),
+		ui.WithNoteValidator(validNotes),
+	}
+	if similarity != nil {
+		opts = append(opts, ui.WithSimilarity(similarity))
+	}
+	uiModel := ui.NewModel(ctx, llm, anki, *noteModel, opts...)
 	p := tea.NewProgram(uiModel, tea.WithAltScreen())
 	if err := p.Start(); err != nil {
 		log.Fatalf("failed to start TUI: %v", err)
@@ -39,20 +119,58 @@ func handleSignals(cancel context.CancelFunc) {
 	cancel()
 }
 
-// initializeAnkiClient returns an Anki client. Adjust the URL here if needed.
-func initializeAnkiClient() *Anki {
-	return NewAnki("http://localhost:8765")
+// initializeAnkiClient returns an Anki client talking to the given AnkiConnect URL.
+func initializeAnkiClient(url string, opts ...AnkiOption) *Anki {
+	return NewAnki(url, opts...)
+}
+
+// initializeSimilarity returns the near-duplicate detection selected by mode, or nil if
+// it is turned off. A threshold of 0 selects the default of the chosen embeddings.
+func initializeSimilarity(mode string, threshold float64, llm LLM) *Similarity {
+	var embedder Embedder
+	switch mode {
+	case "off":
+		return nil
+	case "local":
+		embedder = NewTFIDFEmbedder()
+		if threshold == 0 {
+			threshold = defaultLocalThreshold
+		}
+	case "provider":
+		if rec, ok := llm.(*RecordingLLM); ok {
+			llm = rec.llm
+		}
+		e, ok := llm.(interface {
+			Embedder
+			EmbeddingModel() string
+		})
+		if !ok {
+			log.Fatalf("Failed to initialize embeddings: the LLM provider does not support embeddings")
+		}
+		embedder = NewCachedEmbedder(e, embeddingCachePath(e, e.EmbeddingModel()))
+		if threshold == 0 {
+			threshold = defaultEmbeddingThreshold
+		}
+	default:
+		log.Fatalf("Failed to initialize embeddings: unknown mode %q, expected off, local or provider", mode)
+	}
+	return NewSimilarity(embedder, threshold)
 }
 
-// initializeLLM creates the Gemini LLM client. The model parameter may be empty;
-// if so, a sensible default is chosen.
+// initializeLLM creates the LLM of the provider selected by LLM_PROVIDER (gemini by default).
+// The model parameter may be empty; if so, the provider's configuration or default is used.
+// When LLM_RECORD_DIR is set, all responses are recorded there for replay with the fake provider.
 func initializeLLM(ctx context.Context, model string) LLM {
-	if model == "" {
-		model = "gemini-3-flash-preview"
+	name := os.Getenv("LLM_PROVIDER")
+	if name == "" {
+		name = "gemini"
 	}
-	llm, err := NewGeminiLLM(ctx, model, os.Getenv("GEMINI_API_KEY"))
+	llm, err := newLLM(ctx, name, model, os.Getenv)
 	if err != nil {
-		log.Fatalf("Failed to create Gemini LLM: %v", err)
+		log.Fatalf("Failed to initialize LLM: %v", err)
+	}
+	if dir := os.Getenv("LLM_RECORD_DIR"); dir != "" {
+		return NewRecordingLLM(llm, dir)
 	}
 	return llm
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// AnkiAction is a single action of a Multi request.
+type AnkiAction struct {
+	Action string      `json:"action"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+// AnkiResult is the outcome of a single action of a Multi request.
+type AnkiResult struct {
+	Result interface{}
+	// Err is the error AnkiConnect reported for the action, if any.
+	Err error
+}
+
+// Decode converts the result into v, or returns the error of the action.
+func (r AnkiResult) Decode(v interface{}) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return decodeResult(r.Result, v)
+}
+
+// CreateDeckAction creates the deck; AnkiConnect keeps an existing deck of that name.
+func CreateDeckAction(deckName string) AnkiAction {
+	return AnkiAction{Action: "createDeck", Params: map[string]string{"deck": deckName}}
+}
+
+// ModelFieldNamesAction returns the field names of the note type.
+func ModelFieldNamesAction(modelName string) AnkiAction {
+	return AnkiAction{Action: "modelFieldNames", Params: map[string]string{"modelName": modelName}}
+}
+
+// ModelTemplatesAction returns the card templates of the note type.
+func ModelTemplatesAction(modelName string) AnkiAction {
+	return AnkiAction{Action: "modelTemplates", Params: map[string]string{"modelName": modelName}}
+}
+
+// CanAddNotesAction reports for every note whether it can be added.
+func CanAddNotesAction(notes []Note) AnkiAction {
+	return AnkiAction{Action: "canAddNotes", Params: map[string]interface{}{"notes": notes}}
+}
+
+// FindNotesAction returns the IDs of the notes matching the Anki search query.
+func FindNotesAction(query string) AnkiAction {
+	return AnkiAction{Action: "findNotes", Params: map[string]string{"query": query}}
+}
+
+// AddNoteAction adds the note and returns its ID.
+func AddNoteAction(note Note) AnkiAction {
+	return AnkiAction{Action: "addNote", Params: map[string]interface{}{"note": note}}
+}
+
+// Multi performs the actions in order with a single request to AnkiConnect and returns
+// the result of every action. An action that fails does not stop the following ones; its
+// error is reported in its result. The returned error is only set if the request as a
+// whole failed.
+func (a *Anki) Multi(ctx context.Context, actions ...AnkiAction) ([]AnkiResult, error) {
+	type subRequest struct {
+		AnkiAction
+		Version int    `json:"version"`
+		Key     string `json:"key,omitempty"`
+	}
+	requests := make([]subRequest, len(actions))
+	for i, action := range actions {
+		requests[i] = subRequest{AnkiAction: action, Version: 6, Key: a.apiKey}
+	}
+
+	result, err := a.invoke(ctx, "multi", map[string]interface{}{"actions": requests})
+	if err != nil {
+		return nil, err
+	}
+	var responses []AnkiResponse
+	if err := decodeResult(result, &responses); err != nil {
+		return nil, err
+	}
+	if len(responses) != len(actions) {
+		return nil, fmt.Errorf("got %d results for %d actions", len(responses), len(actions))
+	}
+
+	results := make([]AnkiResult, len(actions))
+	for i, resp := range responses {
+		results[i].Result = resp.Result
+		if resp.Error != "" {
+			results[i].Err = parseAnkiError(resp.Error)
+		}
+	}
+	return results, nil
+}
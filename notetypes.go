@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// NoteType describes an Anki note type and the fields a generated note has to fill.
+type NoteType struct {
+	Name   string
+	Fields []string
+	// Templates maps card names to their front and back templates.
+	Templates map[string]CardTemplate
+	// Cloze reports whether the first field holds cloze deletions.
+	Cloze bool
+}
+
+// CardTemplate is the front and back template of a single card of a note type.
+type CardTemplate struct {
+	Front string `json:"Front"`
+	Back  string `json:"Back"`
+}
+
+// builtinNoteTypes are used when the note types cannot be loaded from Anki.
+var builtinNoteTypes = map[string]NoteType{
+	"Basic": {Name: "Basic", Fields: []string{"Front", "Back"}},
+	"Cloze": {Name: "Cloze", Fields: []string{"Text", "Back Extra"}, Cloze: true},
+}
+
+var (
+	noteTypesMu sync.RWMutex
+	noteTypes   = map[string]NoteType{}
+)
+
+// lookupNoteType returns the note type loaded from Anki with the given name, falling
+// back to the built-in note types.
+func lookupNoteType(name string) (NoteType, error) {
+	noteTypesMu.RLock()
+	nt, ok := noteTypes[name]
+	noteTypesMu.RUnlock()
+	if ok {
+		return nt, nil
+	}
+	if nt, ok := builtinNoteTypes[name]; ok {
+		return nt, nil
+	}
+	return NoteType{}, fmt.Errorf("note model %q not found", name)
+}
+
+// loadNoteTypes fetches all note types with their fields and templates from Anki and
+// makes them available to the LLM backends. Note types that cannot be read are left out.
+func loadNoteTypes(ctx context.Context, anki *Anki) error {
+	names, err := anki.ModelNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	nts, err := anki.NoteTypes(ctx, names)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]NoteType, len(nts))
+	for _, nt := range nts {
+		loaded[nt.Name] = nt
+	}
+
+	noteTypesMu.Lock()
+	noteTypes = loaded
+	noteTypesMu.Unlock()
+	return nil
+}
+
+// schemaFor builds the response schema for a list of notes of the named note type. Every
+// field of the note type becomes a required string property, next to the provenance keys.
+func schemaFor(noteModel string) (*genai.Schema, error) {
+	nt, err := lookupNoteType(noteModel)
+	if err != nil {
+		return nil, err
+	}
+
+	props := make(map[string]*genai.Schema, len(nt.Fields)+2)
+	for _, field := range nt.Fields {
+		props[field] = &genai.Schema{Type: genai.TypeString}
+	}
+	props[sourcePageKey] = &genai.Schema{Type: genai.TypeString, Description: "page number the note was taken from"}
+	props[sourceSectionKey] = &genai.Schema{Type: genai.TypeString, Description: "section the note was taken from"}
+	return &genai.Schema{
+		Type: genai.TypeArray,
+		Items: &genai.Schema{
+			Type:       genai.TypeObject,
+			Properties: props,
+			Required:   append(slices.Clone(nt.Fields), sourcePageKey, sourceSectionKey),
+		},
+	}, nil
+}
+
+// promptFor returns the system prompt for the named note type. Note types without a
+// dedicated prompt get a generic one describing their fields and card templates.
+func promptFor(noteModel string) (string, error) {
+	nt, err := lookupNoteType(noteModel)
+	if err != nil {
+		return "", err
+	}
+	// The dedicated prompts name the fields of the built-in note types, so they do not fit
+	// a collection where these were renamed or localized.
+	if p, ok := prompts[noteModel]; ok && slices.Equal(nt.Fields, builtinNoteTypes[noteModel].Fields) {
+		return withProvenance(p), nil
+	}
+	if nt.Cloze {
+		return withProvenance(clozePromptFor(nt)), nil
+	}
+
+	var b strings.Builder
+	b.WriteString(genericPrompt)
+	fmt.Fprintf(&b, "\n\nThe notes use the Anki note type %q. Fill every one of the following fields:\n", nt.Name)
+	for _, field := range nt.Fields {
+		fmt.Fprintf(&b, "- %q\n", field)
+	}
+	writeTemplates(&b, nt)
+	return withProvenance(b.String()), nil
+}
+
+// clozePromptFor adapts the cloze prompt to a custom cloze note type.
+func clozePromptFor(nt NoteType) string {
+	var b strings.Builder
+	b.WriteString(clozePrompt)
+	fmt.Fprintf(&b, "\n\nThe notes use the Anki cloze note type %q. Put the cloze deletions into %q and fill every one of the following fields:\n", nt.Name, nt.Fields[0])
+	for _, field := range nt.Fields {
+		fmt.Fprintf(&b, "- %q\n", field)
+	}
+	writeTemplates(&b, nt)
+	return b.String()
+}
+
+func writeTemplates(b *strings.Builder, nt NoteType) {
+	if len(nt.Templates) == 0 {
+		return
+	}
+	b.WriteString("\nCards are rendered from these templates, where {{Field}} is replaced by the field's content:\n")
+	names := make([]string, 0, len(nt.Templates))
+	for name := range nt.Templates {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		t := nt.Templates[name]
+		fmt.Fprintf(b, "- %s\n  Question: %s\n  Answer: %s\n", name, t.Front, t.Back)
+	}
+}
+
+// fieldKeyPattern matches everything that is ignored when matching generated keys to fields.
+var fieldKeyPattern = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// fieldKey normalizes a field name so that e.g. "Back Extra" and "back_extra" match.
+func fieldKey(field string) string {
+	return strings.ToLower(fieldKeyPattern.ReplaceAllString(field, ""))
+}
+
+// mapFields maps the keys of a generated note onto the exact field names of the note type.
+// Keys that do not correspond to any field are dropped.
+func mapFields(note map[string]string, fields []string) map[string]string {
+	byKey := make(map[string]string, len(fields))
+	for _, field := range fields {
+		byKey[fieldKey(field)] = field
+	}
+	out := make(map[string]string, len(note))
+	for key, value := range note {
+		if field, ok := byKey[fieldKey(key)]; ok {
+			out[field] = value
+		}
+	}
+	return out
+}
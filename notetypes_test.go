@@ -0,0 +1,22 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMapFields(t *testing.T) {
+	fields := []string{"Text", "Back Extra"}
+	note := map[string]string{
+		"text":        "{{c1::Paris}} is the capital of France.",
+		"back_extra":  "Since 508.",
+		"source_page": "3",
+	}
+	want := map[string]string{
+		"Text":       "{{c1::Paris}} is the capital of France.",
+		"Back Extra": "Since 508.",
+	}
+	if got := mapFields(note, fields); !reflect.DeepEqual(got, want) {
+		t.Errorf("mapFields = %v, want %v", got, want)
+	}
+}
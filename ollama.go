@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OllamaLLM generates notes with a model served by a local Ollama instance. The PDF text
+// is extracted locally so generation works fully offline.
+type OllamaLLM struct {
+	client         *http.Client
+	host           string
+	model          string
+	embeddingModel string
+}
+
+type ollamaChatRequest struct {
+	Model    string         `json:"model"`
+	Messages []chatMessage  `json:"messages"`
+	Format   map[string]any `json:"format"`
+	Stream   bool           `json:"stream"`
+}
+
+type ollamaChatResponse struct {
+	Message chatMessage `json:"message"`
+	Error   string      `json:"error,omitempty"`
+}
+
+func init() {
+	registerProvider("ollama", provider{
+		Required: []string{"OLLAMA_MODEL"},
+		Defaults: map[string]string{
+			"OLLAMA_HOST":            "http://localhost:11434",
+			"OLLAMA_EMBEDDING_MODEL": "nomic-embed-text",
+		},
+		ModelKey: "OLLAMA_MODEL",
+		New: func(_ context.Context, cfg providerConfig) (LLM, error) {
+			llm, err := NewOllamaLLM(cfg["OLLAMA_HOST"], cfg["OLLAMA_MODEL"])
+			if err != nil {
+				return nil, err
+			}
+			llm.(*OllamaLLM).embeddingModel = cfg["OLLAMA_EMBEDDING_MODEL"]
+			return llm, nil
+		},
+	})
+}
+
+// NewOllamaLLM creates an LLM for the Ollama server at host, e.g. "http://localhost:11434".
+func NewOllamaLLM(host, model string) (LLM, error) {
+	if host == "" {
+		return nil, fmt.Errorf("host must not be empty")
+	}
+	if model == "" {
+		return nil, fmt.Errorf("model must not be empty")
+	}
+	return &OllamaLLM{
+		client: &http.Client{},
+		host:   strings.TrimRight(host, "/"),
+		model:  model,
+	}, nil
+}
+
+func (o *OllamaLLM) GenerateAnkiNotes(ctx context.Context, r io.Reader, noteModel string) ([]map[string]string, error) {
+	instructions, err := promptFor(noteModel)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := extractPDFText(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return o.generate(ctx, noteModel, instructions, text)
+}
+
+// RefineNote rewrites a single note following instruction.
+func (o *OllamaLLM) RefineNote(ctx context.Context, noteModel string, note map[string]string, instruction string) ([]map[string]string, error) {
+	instructions, input, err := refineRequest(noteModel, note, instruction)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := o.generate(ctx, noteModel, instructions, input)
+	if err != nil {
+		return nil, err
+	}
+	return finishRefined(noteModel, note, notes)
+}
+
+// generate sends the system prompt and user text and parses the response as notes of noteModel.
+func (o *OllamaLLM) generate(ctx context.Context, noteModel, instructions, text string) ([]map[string]string, error) {
+	schema, err := schemaFor(noteModel)
+	if err != nil {
+		return nil, err
+	}
+
+	req := ollamaChatRequest{
+		Model: o.model,
+		Messages: []chatMessage{
+			{Role: "system", Content: instructions},
+			{Role: "user", Content: text},
+		},
+		Format: jsonSchema(schema),
+	}
+
+	content, err := o.chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var notes []map[string]string
+	if err := json.Unmarshal([]byte(content), &notes); err != nil {
+		return nil, fmt.Errorf("failed to parse generated notes: %v", err)
+	}
+	return notes, nil
+}
+
+// chat sends req to the /api/chat endpoint and returns the message content.
+func (o *OllamaLLM) chat(ctx context.Context, req ollamaChatRequest) (string, error) {
+	var chatResp ollamaChatResponse
+	if err := postJSON(ctx, o.client, o.host+"/api/chat", nil, req, &chatResp); err != nil {
+		return "", err
+	}
+	if chatResp.Error != "" {
+		return "", fmt.Errorf("error from Ollama: %s", chatResp.Error)
+	}
+	return chatResp.Message.Content, nil
+}
+
+// Embed embeds texts with the configured embedding model through the /api/embed endpoint,
+// in batches of embedBatchSize texts.
+func (o *OllamaLLM) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedInBatches(ctx, texts, o.embedBatch)
+}
+
+// EmbeddingModel returns the name of the model used by Embed.
+func (o *OllamaLLM) EmbeddingModel() string {
+	return o.embeddingModel
+}
+
+func (o *OllamaLLM) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	req := map[string]any{"model": o.embeddingModel, "input": texts}
+	var resp struct {
+		Embeddings [][]float32 `json:"embeddings"`
+		Error      string      `json:"error,omitempty"`
+	}
+	if err := postJSON(ctx, o.client, o.host+"/api/embed", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("error from Ollama: %s", resp.Error)
+	}
+	return resp.Embeddings, nil
+}
+
+func (o *OllamaLLM) Close() error {
+	o.client.CloseIdleConnections()
+	return nil
+}
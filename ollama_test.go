@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOllamaRequestsNotesWithSchemaFormat(t *testing.T) {
+	fakePoppler(t, "A mutex is a lock.")
+	var req ollamaChatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(ollamaChatResponse{
+			Message: chatMessage{Role: "assistant", Content: `[{"Front":"What is a mutex?","Back":"A lock."}]`},
+		})
+	}))
+	defer srv.Close()
+
+	llm, err := NewOllamaLLM(srv.URL, "test-model")
+	if err != nil {
+		t.Fatalf("NewOllamaLLM: %v", err)
+	}
+	notes, err := llm.GenerateAnkiNotes(context.Background(), strings.NewReader("%PDF-1.4"), "Basic")
+	if err != nil {
+		t.Fatalf("GenerateAnkiNotes: %v", err)
+	}
+	if len(notes) != 1 || notes[0]["Front"] != "What is a mutex?" {
+		t.Errorf("notes = %v, want the note of the response", notes)
+	}
+
+	if req.Model != "test-model" || req.Stream || len(req.Messages) != 2 || !strings.Contains(req.Messages[1].Content, "A mutex is a lock.") {
+		t.Errorf("request = %+v, want a non-streaming request with the PDF text", req)
+	}
+	items, _ := req.Format["items"].(map[string]any)
+	if req.Format["type"] != "array" || items["type"] != "object" {
+		t.Errorf("format = %v, want the JSON schema of an array of notes", req.Format)
+	}
+}
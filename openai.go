@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// OpenAILLM generates notes through any OpenAI-compatible /v1/chat/completions endpoint,
+// such as OpenAI itself, vLLM or llama.cpp. The PDF text is extracted locally, so only
+// plain text is sent to the server.
+type OpenAILLM struct {
+	client         *http.Client
+	baseURL        string
+	apiKey         string
+	model          string
+	embeddingModel string
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatResponseFormat struct {
+	Type       string         `json:"type"`
+	JSONSchema chatJSONSchema `json:"json_schema"`
+}
+
+type chatJSONSchema struct {
+	Name   string         `json:"name"`
+	Strict bool           `json:"strict"`
+	Schema map[string]any `json:"schema"`
+}
+
+type chatRequest struct {
+	Model          string             `json:"model"`
+	Messages       []chatMessage      `json:"messages"`
+	ResponseFormat chatResponseFormat `json:"response_format"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func init() {
+	registerProvider("openai", provider{
+		Required: []string{"OPENAI_MODEL"},
+		Defaults: map[string]string{
+			"OPENAI_BASE_URL":        "https://api.openai.com/v1",
+			"OPENAI_API_KEY":         "",
+			"OPENAI_EMBEDDING_MODEL": "text-embedding-3-small",
+		},
+		ModelKey: "OPENAI_MODEL",
+		New: func(_ context.Context, cfg providerConfig) (LLM, error) {
+			llm, err := NewOpenAILLM(cfg["OPENAI_BASE_URL"], cfg["OPENAI_API_KEY"], cfg["OPENAI_MODEL"])
+			if err != nil {
+				return nil, err
+			}
+			llm.(*OpenAILLM).embeddingModel = cfg["OPENAI_EMBEDDING_MODEL"]
+			return llm, nil
+		},
+	})
+}
+
+// NewOpenAILLM creates an LLM for the chat completions API below baseURL, e.g.
+// "https://api.openai.com/v1" or "http://localhost:8000/v1". The apiKey may be empty
+// for local servers that do not require authentication.
+func NewOpenAILLM(baseURL, apiKey, model string) (LLM, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("base URL must not be empty")
+	}
+	if model == "" {
+		return nil, fmt.Errorf("model must not be empty")
+	}
+	return &OpenAILLM{
+		client:  &http.Client{},
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+	}, nil
+}
+
+func (o *OpenAILLM) GenerateAnkiNotes(ctx context.Context, r io.Reader, noteModel string) ([]map[string]string, error) {
+	instructions, err := promptFor(noteModel)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := extractPDFText(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return o.generate(ctx, noteModel, instructions, text)
+}
+
+// RefineNote rewrites a single note following instruction.
+func (o *OpenAILLM) RefineNote(ctx context.Context, noteModel string, note map[string]string, instruction string) ([]map[string]string, error) {
+	instructions, input, err := refineRequest(noteModel, note, instruction)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := o.generate(ctx, noteModel, instructions, input)
+	if err != nil {
+		return nil, err
+	}
+	return finishRefined(noteModel, note, notes)
+}
+
+// generate sends the system prompt and user text and parses the response as notes of noteModel.
+func (o *OpenAILLM) generate(ctx context.Context, noteModel, instructions, text string) ([]map[string]string, error) {
+	schema, err := schemaFor(noteModel)
+	if err != nil {
+		return nil, err
+	}
+
+	// Structured outputs require an object at the root, so the notes array is wrapped.
+	req := chatRequest{
+		Model: o.model,
+		Messages: []chatMessage{
+			{Role: "system", Content: instructions},
+			{Role: "user", Content: text},
+		},
+		ResponseFormat: chatResponseFormat{
+			Type: "json_schema",
+			JSONSchema: chatJSONSchema{
+				Name:   "anki_notes",
+				Strict: true,
+				Schema: map[string]any{
+					"type":                 "object",
+					"properties":           map[string]any{"notes": jsonSchema(schema)},
+					"required":             []string{"notes"},
+					"additionalProperties": false,
+				},
+			},
+		},
+	}
+
+	content, err := o.complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var out struct {
+		Notes []map[string]string `json:"notes"`
+	}
+	if err := json.Unmarshal([]byte(content), &out); err != nil {
+		return nil, fmt.Errorf("failed to parse generated notes: %v", err)
+	}
+	return out.Notes, nil
+}
+
+// complete sends req and returns the content of the first choice.
+func (o *OpenAILLM) complete(ctx context.Context, req chatRequest) (string, error) {
+	var chatResp chatResponse
+	if err := postJSON(ctx, o.client, o.baseURL+"/chat/completions", o.header(), req, &chatResp); err != nil {
+		return "", err
+	}
+	if chatResp.Error != nil {
+		return "", fmt.Errorf("error from chat completions API: %s", chatResp.Error.Message)
+	}
+	if len(chatResp.Choices) == 0 {
+		return "", fmt.Errorf("chat completions API returned no choices")
+	}
+	return chatResp.Choices[0].Message.Content, nil
+}
+
+// Embed embeds texts with the configured embedding model through the /embeddings endpoint,
+// in batches of embedBatchSize texts.
+func (o *OpenAILLM) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedInBatches(ctx, texts, o.embedBatch)
+}
+
+// EmbeddingModel returns the name of the model used by Embed.
+func (o *OpenAILLM) EmbeddingModel() string {
+	return o.embeddingModel
+}
+
+func (o *OpenAILLM) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	req := map[string]any{"model": o.embeddingModel, "input": texts}
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error,omitempty"`
+	}
+	if err := postJSON(ctx, o.client, o.baseURL+"/embeddings", o.header(), req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("error from embeddings API: %s", resp.Error.Message)
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embeddings API returned unexpected index %d", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// header returns the headers sent with every request.
+func (o *OpenAILLM) header() http.Header {
+	header := http.Header{}
+	if o.apiKey != "" {
+		header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	return header
+}
+
+func (o *OpenAILLM) Close() error {
+	o.client.CloseIdleConnections()
+	return nil
+}
+
+// jsonSchema converts a genai.Schema into the equivalent JSON Schema document so the
+// schemas built by schemaFor can be shared by providers that accept standard JSON Schema.
+func jsonSchema(s *genai.Schema) map[string]any {
+	out := map[string]any{}
+	switch s.Type {
+	case genai.TypeString:
+		out["type"] = "string"
+	case genai.TypeNumber:
+		out["type"] = "number"
+	case genai.TypeInteger:
+		out["type"] = "integer"
+	case genai.TypeBoolean:
+		out["type"] = "boolean"
+	case genai.TypeArray:
+		out["type"] = "array"
+	case genai.TypeObject:
+		out["type"] = "object"
+	}
+	if s.Description != "" {
+		out["description"] = s.Description
+	}
+	if len(s.Enum) > 0 {
+		out["enum"] = s.Enum
+	}
+	if s.Items != nil {
+		out["items"] = jsonSchema(s.Items)
+	}
+	if s.Type == genai.TypeObject {
+		props := make(map[string]any, len(s.Properties))
+		for name, p := range s.Properties {
+			props[name] = jsonSchema(p)
+		}
+		out["properties"] = props
+		out["additionalProperties"] = false
+		if len(s.Required) > 0 {
+			out["required"] = s.Required
+		}
+	}
+	return out
+}
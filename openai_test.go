@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIRequestsNotesWrappedInObject(t *testing.T) {
+	fakePoppler(t, "A mutex is a lock.")
+	var req chatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]any{"choices": []any{
+			map[string]any{"message": chatMessage{Role: "assistant", Content: `{"notes":[{"Front":"What is a mutex?","Back":"A lock."}]}`}},
+		}})
+	}))
+	defer srv.Close()
+
+	llm, err := NewOpenAILLM(srv.URL+"/v1", "secret", "test-model")
+	if err != nil {
+		t.Fatalf("NewOpenAILLM: %v", err)
+	}
+	notes, err := llm.GenerateAnkiNotes(context.Background(), strings.NewReader("%PDF-1.4"), "Basic")
+	if err != nil {
+		t.Fatalf("GenerateAnkiNotes: %v", err)
+	}
+	if len(notes) != 1 || notes[0]["Front"] != "What is a mutex?" {
+		t.Errorf("notes = %v, want the note of the response", notes)
+	}
+
+	if req.Model != "test-model" || len(req.Messages) != 2 || !strings.Contains(req.Messages[1].Content, "A mutex is a lock.") {
+		t.Errorf("request = %+v, want the model and the PDF text", req)
+	}
+	format := req.ResponseFormat
+	if format.Type != "json_schema" || !format.JSONSchema.Strict {
+		t.Errorf("response format = %+v, want a strict json_schema", format)
+	}
+	schema := format.JSONSchema.Schema
+	notesSchema, _ := schema["properties"].(map[string]any)["notes"].(map[string]any)
+	required, _ := schema["required"].([]any)
+	if schema["type"] != "object" || notesSchema["type"] != "array" || !slices.Equal(required, []any{"notes"}) {
+		t.Errorf("schema = %v, want an object with a required notes array", schema)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// extractPDFPages extracts the text of every page of the PDF in r using poppler's
+// pdftotext, which must be available on the PATH. The returned slice holds one entry per page.
+func extractPDFPages(ctx context.Context, r io.Reader) ([]string, error) {
+	tmp, err := os.CreateTemp("", "anki-llm-*.pdf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		return nil, fmt.Errorf("failed to buffer pdf: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to buffer pdf: %v", err)
+	}
+
+	out, err := runPoppler(ctx, "pdftotext", "-enc", "UTF-8", "-layout", tmp.Name(), "-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract pdf text: %v", err)
+	}
+
+	// pdftotext terminates every page with a form feed.
+	pages := strings.Split(out, "\f")
+	if len(pages) > 1 && strings.TrimSpace(pages[len(pages)-1]) == "" {
+		pages = pages[:len(pages)-1]
+	}
+	return pages, nil
+}
+
+// extractPDFText returns the text of the whole PDF in r with every page introduced by a
+// marker such as "[Page 3]", so the LLM can tell where information was found.
+func extractPDFText(ctx context.Context, r io.Reader) (string, error) {
+	pages, err := extractPDFPages(ctx, r)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i, page := range pages {
+		fmt.Fprintf(&b, "[Page %d]\n%s\n\n", i+1, page)
+	}
+	return b.String(), nil
+}
+
+// pageRange is an inclusive range of 1-based page numbers.
+type pageRange struct {
+	First, Last int
+}
+
+func (r pageRange) String() string {
+	if r.First == r.Last {
+		return strconv.Itoa(r.First)
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// parsePageRanges parses a comma separated list of pages and page ranges such as
+// "45-78,90". An empty spec yields no ranges.
+func parsePageRanges(spec string) ([]pageRange, error) {
+	var ranges []pageRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		r, err := parsePageRange(first, last, isRange)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page range %q: %v", part, err)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parsePageRange(first, last string, isRange bool) (pageRange, error) {
+	f, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return pageRange{}, fmt.Errorf("not a page number")
+	}
+	l := f
+	if isRange {
+		if l, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+			return pageRange{}, fmt.Errorf("not a page number")
+		}
+	}
+	if f < 1 || l < f {
+		return pageRange{}, fmt.Errorf("pages must be ascending and start at 1")
+	}
+	return pageRange{First: f, Last: l}, nil
+}
+
+// selectPages returns the page numbers covered by ranges in order without repetitions, or
+// all pages of the document if ranges is empty.
+func selectPages(ranges []pageRange, pageCount int) ([]int, error) {
+	if len(ranges) == 0 {
+		ranges = []pageRange{{First: 1, Last: pageCount}}
+	}
+	seen := map[int]bool{}
+	var pages []int
+	for _, r := range ranges {
+		if r.Last > pageCount {
+			return nil, fmt.Errorf("page range %s exceeds the %d pages of the document", r, pageCount)
+		}
+		for page := r.First; page <= r.Last; page++ {
+			if !seen[page] {
+				seen[page] = true
+				pages = append(pages, page)
+			}
+		}
+	}
+	return pages, nil
+}
+
+// toPageRanges compresses page numbers into ranges of consecutive pages.
+func toPageRanges(pages []int) []pageRange {
+	var ranges []pageRange
+	for _, page := range pages {
+		if n := len(ranges); n > 0 && ranges[n-1].Last+1 == page {
+			ranges[n-1].Last = page
+			continue
+		}
+		ranges = append(ranges, pageRange{First: page, Last: page})
+	}
+	return ranges
+}
+
+var pagesPattern = regexp.MustCompile(`(?m)^Pages:\s+(\d+)`)
+
+// pdfPageCount returns the number of pages of the PDF at path using poppler's pdfinfo.
+func pdfPageCount(ctx context.Context, path string) (int, error) {
+	out, err := runPoppler(ctx, "pdfinfo", path)
+	if err != nil {
+		return 0, err
+	}
+	m := pagesPattern.FindStringSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("failed to read page count of %s", path)
+	}
+	return strconv.Atoi(m[1])
+}
+
+// slicePDF returns a new PDF containing only the pages in ranges of the PDF at path, in
+// the given order, using poppler's pdfseparate and pdfunite.
+func slicePDF(ctx context.Context, path string, ranges []pageRange) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "anki-llm-pages-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pattern := filepath.Join(dir, "page-%d.pdf")
+	var files []string
+	for _, r := range ranges {
+		if _, err := runPoppler(ctx, "pdfseparate", "-f", strconv.Itoa(r.First), "-l", strconv.Itoa(r.Last), path, pattern); err != nil {
+			return nil, err
+		}
+		for page := r.First; page <= r.Last; page++ {
+			files = append(files, fmt.Sprintf(pattern, page))
+		}
+	}
+	if len(files) == 1 {
+		return readSlice(files[0])
+	}
+
+	out := filepath.Join(dir, "slice.pdf")
+	if _, err := runPoppler(ctx, "pdfunite", append(files, out)...); err != nil {
+		return nil, err
+	}
+	return readSlice(out)
+}
+
+func readSlice(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pdf slice: %v", err)
+	}
+	return data, nil
+}
+
+// runPoppler runs one of the poppler command line tools and returns its standard output.
+func runPoppler(ctx context.Context, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); errors.Is(err, exec.ErrNotFound) {
+		return "", fmt.Errorf("%s is not installed, install poppler-utils to extract text and select pages: %w", name, err)
+	} else if err != nil {
+		return "", fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakePoppler puts a pdftotext printing the given pages on the PATH, so text extraction
+// can be tested without poppler installed.
+func fakePoppler(t *testing.T, pages ...string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake poppler tools need a POSIX shell")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\nprintf '%s' '" + strings.Join(pages, "\f") + "\f'\n"
+	if err := os.WriteFile(filepath.Join(dir, "pdftotext"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestParsePageRanges(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []pageRange
+		wantErr bool
+	}{
+		{spec: "", want: nil},
+		{spec: "7", want: []pageRange{{7, 7}}},
+		{spec: "45-78,90", want: []pageRange{{45, 78}, {90, 90}}},
+		{spec: " 1 - 3 , ,5", want: []pageRange{{1, 3}, {5, 5}}},
+		{spec: "0", wantErr: true},
+		{spec: "5-3", wantErr: true},
+		{spec: "a-3", wantErr: true},
+		{spec: "3-", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePageRanges(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePageRanges(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePageRanges(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestSelectPages(t *testing.T) {
+	got, err := selectPages([]pageRange{{3, 5}, {4, 6}, {1, 1}}, 10)
+	if err != nil || !reflect.DeepEqual(got, []int{3, 4, 5, 6, 1}) {
+		t.Errorf("selectPages = %v, %v, want pages 3 to 6 and 1", got, err)
+	}
+	if got, _ := selectPages(nil, 3); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("selectPages without ranges = %v, want all pages", got)
+	}
+	if _, err := selectPages([]pageRange{{8, 12}}, 10); err == nil {
+		t.Error("selectPages beyond the last page succeeded")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Keys of the provenance information carried by every generated note next to its fields.
+const (
+	sourceFileKey    = "source_file"
+	sourcePageKey    = "source_page"
+	sourceSectionKey = "source_section"
+)
+
+var provenancePrompt = `
+
+Source Information:
+- For every note, set "source_page" to the number of the page the information was taken from, counting from 1 at the first page of the provided document. Use the page markers such as "[Page 3]" if the document contains them.
+- Set "source_section" to the title of the chapter or section the information belongs to, or leave it empty if there is none.`
+
+// withProvenance appends the provenance instructions to a system prompt.
+func withProvenance(prompt string) string {
+	return prompt + provenancePrompt
+}
+
+// remapSourcePages translates the page numbers of notes generated from a slice of a
+// document back to the original document; pages holds the original number of every page
+// of the slice.
+func remapSourcePages(notes []map[string]string, pages []int) {
+	for _, note := range notes {
+		p, err := strconv.Atoi(strings.TrimSpace(note[sourcePageKey]))
+		if err != nil || p < 1 || p > len(pages) {
+			continue
+		}
+		note[sourcePageKey] = strconv.Itoa(pages[p-1])
+	}
+}
+
+// stampSourceFile records the base name of the file behind r, if r is a file, in every note.
+func stampSourceFile(notes []map[string]string, r io.Reader) {
+	f, ok := r.(interface{ Name() string })
+	if !ok {
+		return
+	}
+	name := filepath.Base(f.Name())
+	for _, note := range notes {
+		note[sourceFileKey] = name
+	}
+}
+
+// formatSource describes the provenance of a note for a source field, e.g.
+// "lecture03.pdf, p. 12 (Mutual Exclusion)".
+func formatSource(note map[string]string) string {
+	var parts []string
+	if file := note[sourceFileKey]; file != "" {
+		parts = append(parts, file)
+	}
+	if page := note[sourcePageKey]; page != "" {
+		parts = append(parts, "p. "+page)
+	}
+	s := strings.Join(parts, ", ")
+	if section := note[sourceSectionKey]; section != "" {
+		if s == "" {
+			return section
+		}
+		s += " (" + section + ")"
+	}
+	return s
+}
+
+var tagUnsafePattern = regexp.MustCompile(`[\s:"]+`)
+
+// sourceTag returns a hierarchical tag such as "source::lecture03::p12" for the note,
+// or an empty string if its source file is unknown.
+func sourceTag(note map[string]string) string {
+	file := note[sourceFileKey]
+	if file == "" {
+		return ""
+	}
+	stem := strings.TrimSuffix(file, filepath.Ext(file))
+	tag := "source::" + tagUnsafePattern.ReplaceAllString(stem, "_")
+	if page := strings.TrimSpace(note[sourcePageKey]); page != "" {
+		tag += fmt.Sprintf("::p%s", tagUnsafePattern.ReplaceAllString(page, "_"))
+	}
+	return tag
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormatSource(t *testing.T) {
+	tests := []struct {
+		note map[string]string
+		want string
+	}{
+		{note: map[string]string{sourceFileKey: "lecture03.pdf", sourcePageKey: "12", sourceSectionKey: "Mutual Exclusion"}, want: "lecture03.pdf, p. 12 (Mutual Exclusion)"},
+		{note: map[string]string{sourceFileKey: "lecture03.pdf", sourcePageKey: "12"}, want: "lecture03.pdf, p. 12"},
+		{note: map[string]string{sourceSectionKey: "Mutual Exclusion"}, want: "Mutual Exclusion"},
+		{note: map[string]string{}, want: ""},
+	}
+	for _, tt := range tests {
+		if got := formatSource(tt.note); got != tt.want {
+			t.Errorf("formatSource(%v) = %q, want %q", tt.note, got, tt.want)
+		}
+	}
+}
+
+func TestSourceTag(t *testing.T) {
+	tests := []struct {
+		note map[string]string
+		want string
+	}{
+		{note: map[string]string{sourceFileKey: "lecture03.pdf", sourcePageKey: "12"}, want: "source::lecture03::p12"},
+		{note: map[string]string{sourceFileKey: "week 1: intro.pdf"}, want: "source::week_1_intro"},
+		{note: map[string]string{sourcePageKey: "12"}, want: ""},
+	}
+	for _, tt := range tests {
+		if got := sourceTag(tt.note); got != tt.want {
+			t.Errorf("sourceTag(%v) = %q, want %q", tt.note, got, tt.want)
+		}
+	}
+}
+
+func TestRemapSourcePages(t *testing.T) {
+	notes := []map[string]string{
+		{sourcePageKey: "1"},
+		{sourcePageKey: " 3 "},
+		{sourcePageKey: "4"},
+		{sourcePageKey: "unknown"},
+	}
+	// the slice consists of pages 45, 46 and 90 of the document
+	remapSourcePages(notes, []int{45, 46, 90})
+	var got []string
+	for _, note := range notes {
+		got = append(got, note[sourcePageKey])
+	}
+	if want := []string{"45", "90", "4", "unknown"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("remapped pages = %q, want %q", got, want)
+	}
+}
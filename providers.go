@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// providerConfig holds the configuration of a provider keyed by environment variable name.
+type providerConfig map[string]string
+
+// provider describes an LLM backend that can be selected through LLM_PROVIDER.
+type provider struct {
+	// Required lists the configuration keys that must be non-empty.
+	Required []string
+	// Defaults maps optional configuration keys to the value used when they are unset.
+	Defaults map[string]string
+	// ModelKey is the configuration key holding the model name; the -model flag overrides it.
+	ModelKey string
+	// New creates the LLM from the resolved configuration.
+	New func(ctx context.Context, cfg providerConfig) (LLM, error)
+}
+
+var providers = map[string]provider{}
+
+// registerProvider makes a backend available under name. It is meant to be called from
+// the init function of the file implementing the backend.
+func registerProvider(name string, p provider) {
+	if _, ok := providers[name]; ok {
+		panic(fmt.Sprintf("provider %q registered twice", name))
+	}
+	providers[name] = p
+}
+
+// providerNames returns the names of all registered providers in sorted order.
+func providerNames() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// newLLM creates the LLM of the named provider. Configuration values are read through
+// lookup, usually os.Getenv; a non-empty model takes precedence over the provider's model key.
+func newLLM(ctx context.Context, name, model string, lookup func(string) string) (LLM, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider %q (available: %s)", name, strings.Join(providerNames(), ", "))
+	}
+
+	cfg := providerConfig{}
+	for key, def := range p.Defaults {
+		cfg[key] = def
+	}
+	for _, key := range p.Required {
+		cfg[key] = ""
+	}
+	for key := range cfg {
+		if v := lookup(key); v != "" {
+			cfg[key] = v
+		}
+	}
+	if p.ModelKey != "" && model != "" {
+		cfg[p.ModelKey] = model
+	}
+
+	var missing []string
+	for _, key := range p.Required {
+		if cfg[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("provider %q is missing configuration: %s", name, strings.Join(missing, ", "))
+	}
+
+	llm, err := p.New(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s LLM: %v", name, err)
+	}
+	return llm, nil
+}
+
+// StatusError is returned by the HTTP based providers when the server answers with a
+// status other than 200 OK.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// Message is the error message from the response body, if there is one.
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return "unexpected status " + e.Status
+	}
+	return fmt.Sprintf("unexpected status %s: %s", e.Status, e.Message)
+}
+
+// statusError returns a StatusError for resp with the message found in body, which may be
+// an OpenAI style {"error": {"message": ...}} or an Ollama style {"error": "..."} object.
+func statusError(resp *http.Response, body []byte) *StatusError {
+	e := &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	var payload struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) != nil || len(payload.Error) == 0 {
+		return e
+	}
+	var detail struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(payload.Error, &e.Message) != nil && json.Unmarshal(payload.Error, &detail) == nil {
+		e.Message = detail.Message
+	}
+	return e
+}
+
+// postJSON sends body as JSON to url and decodes the response into out. The header, which
+// may be nil, is added to the request. Responses with another status than 200 OK are
+// returned as *StatusError.
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp, respBody)
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	return nil
+}
+
+// embedBatchSize is the number of texts sent in a single embedding request.
+const embedBatchSize = 100
+
+// embedInBatches embeds texts by calling embed with batches of at most embedBatchSize
+// texts and returns the vectors of all batches in order.
+func embedInBatches(ctx context.Context, texts []string, embed func(ctx context.Context, batch []string) ([][]float32, error)) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		batch := texts[start:min(start+embedBatchSize, len(texts))]
+		batchVectors, err := embed(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(batchVectors) != len(batch) {
+			return nil, fmt.Errorf("got %d embeddings for %d texts", len(batchVectors), len(batch))
+		}
+		vectors = append(vectors, batchVectors...)
+	}
+	return vectors, nil
+}
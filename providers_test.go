@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNewLLMConfig(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+	tests := []struct {
+		name     string
+		provider string
+		model    string
+		env      map[string]string
+		wantErr  string
+	}{
+		{name: "unknown provider", provider: "mistral", wantErr: `unknown LLM provider "mistral"`},
+		{name: "missing model", provider: "openai", env: map[string]string{"OPENAI_API_KEY": "secret"}, wantErr: "missing configuration: OPENAI_MODEL"},
+		{name: "missing api key", provider: "gemini", wantErr: "missing configuration: GEMINI_API_KEY"},
+		{name: "missing anthropic config", provider: "anthropic", wantErr: "missing configuration: ANTHROPIC_API_KEY, ANTHROPIC_MODEL"},
+		{name: "anthropic model from flag", provider: "anthropic", model: "claude-sonnet-4-5", env: map[string]string{"ANTHROPIC_API_KEY": "secret"}},
+		{name: "model from env", provider: "ollama", env: map[string]string{"OLLAMA_MODEL": "llama3"}},
+		{name: "model from flag", provider: "openai", model: "gpt-4o-mini"},
+	}
+	for _, tt := range tests {
+		llm, err := newLLM(context.Background(), tt.provider, tt.model, env(tt.env))
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: newLLM: %v", tt.name, err)
+			} else {
+				llm.Close()
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: newLLM error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "openai", body: `{"error": {"message": "rate limit reached"}}`, want: "rate limit reached"},
+		{name: "ollama", body: `{"error": "model not found"}`, want: "model not found"},
+		{name: "no error", body: `{"status": "busy"}`, want: ""},
+		{name: "not json", body: `<html>Bad Gateway</html>`, want: ""},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"}
+		err := statusError(resp, []byte(tt.body))
+		if err.StatusCode != http.StatusTooManyRequests || err.Message != tt.want {
+			t.Errorf("%s: statusError = %+v, want message %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPostJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q", got)
+		}
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["input"] == "fail" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error": "slow down"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"output": req["input"]})
+	}))
+	defer srv.Close()
+
+	header := http.Header{"Authorization": {"Bearer secret"}}
+	var out map[string]string
+	if err := postJSON(context.Background(), srv.Client(), srv.URL, header, map[string]string{"input": "hi"}, &out); err != nil {
+		t.Fatalf("postJSON: %v", err)
+	}
+	if out["output"] != "hi" {
+		t.Errorf("postJSON decoded %v", out)
+	}
+
+	err := postJSON(context.Background(), srv.Client(), srv.URL, header, map[string]string{"input": "fail"}, &out)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests || statusErr.Message != "slow down" {
+		t.Errorf("postJSON with status 429 = %v, want *StatusError", err)
+	}
+}
+
+func TestEmbedInBatches(t *testing.T) {
+	texts := make([]string, 2*embedBatchSize+1)
+	for i := range texts {
+		texts[i] = strings.Repeat("x", i)
+	}
+	var sizes []int
+	vectors, err := embedInBatches(context.Background(), texts, func(_ context.Context, batch []string) ([][]float32, error) {
+		sizes = append(sizes, len(batch))
+		vectors := make([][]float32, len(batch))
+		for i, text := range batch {
+			vectors[i] = []float32{float32(len(text))}
+		}
+		return vectors, nil
+	})
+	if err != nil {
+		t.Fatalf("embedInBatches: %v", err)
+	}
+	if !slices.Equal(sizes, []int{embedBatchSize, embedBatchSize, 1}) {
+		t.Errorf("batch sizes = %v", sizes)
+	}
+	for i, v := range vectors {
+		if v[0] != float32(i) {
+			t.Fatalf("vector %d = %v, want the vectors in order", i, v)
+		}
+	}
+
+	_, err = embedInBatches(context.Background(), texts[:3], func(context.Context, []string) ([][]float32, error) {
+		return [][]float32{{1}}, nil
+	})
+	if err == nil {
+		t.Error("embedInBatches accepted fewer vectors than texts")
+	}
+}
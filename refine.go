@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Refiner is implemented by LLMs that can rewrite a single note following an instruction
+// such as "make it shorter" or "split into two".
+type Refiner interface {
+	// RefineNote returns the rewritten note, or several notes if the instruction asks to
+	// split it.
+	RefineNote(ctx context.Context, noteModel string, note map[string]string, instruction string) ([]map[string]string, error)
+}
+
+var refinePrompt = `
+
+Refining a Note:
+- Instead of generating notes from a document, rewrite the existing note given as JSON by the user, following the user's instruction.
+- Keep every rule above for the rewritten note.
+- Return exactly one note, unless the instruction asks to split the note; then return all resulting notes.
+- Keep "source_page" and "source_section" of the existing note.`
+
+// refineRequest returns the system prompt and the user message asking the LLM to rewrite
+// note following instruction.
+func refineRequest(noteModel string, note map[string]string, instruction string) (string, string, error) {
+	instructions, err := promptFor(noteModel)
+	if err != nil {
+		return "", "", err
+	}
+	data, err := json.MarshalIndent(note, "", "  ")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal note: %v", err)
+	}
+	return instructions + refinePrompt, fmt.Sprintf("Note:\n%s\n\nInstruction: %s", data, instruction), nil
+}
+
+// finishRefined checks the notes returned for a refinement of note and carries over its
+// source file, which is not part of the response schema. Unlike a generation, a refinement
+// containing a malformed note fails, so no part of a split note is lost silently.
+func finishRefined(noteModel string, note map[string]string, refined []map[string]string) ([]map[string]string, error) {
+	if len(refined) == 0 {
+		return nil, fmt.Errorf("refinement returned no notes")
+	}
+	if _, dropped := validNotes(noteModel, refined); dropped > 0 {
+		return nil, fmt.Errorf("refinement returned %d malformed notes", dropped)
+	}
+	for _, r := range refined {
+		if file := note[sourceFileKey]; file != "" {
+			r[sourceFileKey] = file
+		}
+	}
+	return refined, nil
+}
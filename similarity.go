@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// Embedder turns texts into vectors whose cosine similarity reflects how similar the
+// texts are in meaning.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Default similarity thresholds of provider embeddings and the local TF-IDF fallback,
+// whose scores are considerably lower for paraphrases.
+const (
+	defaultEmbeddingThreshold = 0.9
+	defaultLocalThreshold     = 0.6
+)
+
+// Similarity finds near-duplicate notes by comparing their embeddings with each other and
+// with the notes already in the target deck.
+type Similarity struct {
+	embedder  Embedder
+	threshold float64
+}
+
+// NewSimilarity creates a Similarity that considers notes with a cosine similarity of at
+// least threshold near-duplicates.
+func NewSimilarity(embedder Embedder, threshold float64) *Similarity {
+	return &Similarity{embedder: embedder, threshold: threshold}
+}
+
+// FindSimilar clusters near-duplicates among notes and matches them against the existing
+// notes of the deck, as returned by Anki.DeckNotes. For every note it returns a cluster ID,
+// equal for notes that are near-duplicates of each other and 0 for notes without one, and
+// the first field of the most similar existing note, or an empty string.
+func (s *Similarity) FindSimilar(ctx context.Context, modelName string, notes []map[string]string, existing [][]string) ([]int, []string, error) {
+	nt, err := lookupNoteType(modelName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	texts := make([]string, 0, len(notes)+len(existing))
+	for _, note := range notes {
+		fields := mapFields(note, nt.Fields)
+		values := make([]string, 0, len(nt.Fields))
+		for _, field := range nt.Fields {
+			values = append(values, fields[field])
+		}
+		texts = append(texts, noteText(values))
+	}
+	for _, values := range existing {
+		texts = append(texts, noteText(values))
+	}
+
+	vectors, err := s.embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to embed notes: %v", err)
+	}
+	if len(vectors) != len(texts) {
+		return nil, nil, fmt.Errorf("failed to embed notes: got %d embeddings for %d notes", len(vectors), len(texts))
+	}
+
+	// Union-find over the generated notes.
+	parent := make([]int, len(notes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	similarTo := make([]string, len(notes))
+	for i := range notes {
+		for j := i + 1; j < len(notes); j++ {
+			if cosine(vectors[i], vectors[j]) >= s.threshold {
+				parent[find(j)] = find(i)
+			}
+		}
+		best := s.threshold
+		for k, values := range existing {
+			if sim := cosine(vectors[i], vectors[len(notes)+k]); sim >= best && len(values) > 0 {
+				best = sim
+				similarTo[i] = values[0]
+			}
+		}
+	}
+
+	size := map[int]int{}
+	for i := range notes {
+		size[find(i)]++
+	}
+	ids := map[int]int{}
+	clusters := make([]int, len(notes))
+	for i := range notes {
+		root := find(i)
+		if size[root] < 2 {
+			continue
+		}
+		if _, ok := ids[root]; !ok {
+			ids[root] = len(ids) + 1
+		}
+		clusters[i] = ids[root]
+	}
+	return clusters, similarTo, nil
+}
+
+// noteText joins the field values of a note into plain text for embedding.
+func noteText(values []string) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if v = normalizeText(v); v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// TFIDFEmbedder is an offline Embedder that weights the words of every text by TF-IDF
+// across all texts of a call and hashes them into a fixed number of dimensions.
+type TFIDFEmbedder struct {
+	dims int
+}
+
+// NewTFIDFEmbedder creates a TFIDFEmbedder producing vectors with 4096 dimensions.
+func NewTFIDFEmbedder() *TFIDFEmbedder {
+	return &TFIDFEmbedder{dims: 4096}
+}
+
+func (t *TFIDFEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	docs := make([]map[string]int, len(texts))
+	df := map[string]int{}
+	for i, text := range texts {
+		docs[i] = map[string]int{}
+		for _, word := range strings.Fields(normalizeText(text)) {
+			if len([]rune(word)) < 3 || stopWords[word] {
+				continue
+			}
+			if docs[i][word] == 0 {
+				df[word]++
+			}
+			docs[i][word]++
+		}
+	}
+
+	n := float64(len(texts))
+	vectors := make([][]float32, len(texts))
+	for i, doc := range docs {
+		v := make([]float32, t.dims)
+		for word, tf := range doc {
+			idf := math.Log((n+1)/float64(df[word]+1)) + 1
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			v[h.Sum32()%uint32(t.dims)] += float32(float64(tf) * idf)
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+// stopWords are the common English and German function words, such as articles, pronouns,
+// prepositions and auxiliary verbs, which carry no meaning on their own. Words shorter than
+// three letters are ignored anyway.
+var stopWords = wordSet(`
+about above after again against all any are aren because been before being below between both but can
+couldn did didn does doesn doing don down during each few for from further had hadn has hasn have haven having
+her here hers herself him himself his how into its itself just more most mustn myself needn nor not now off once
+only other our ours ourselves out over own same she should shouldn some such than that the their theirs them
+themselves then there these they this those through too under until very was wasn were weren what when where
+which while who whom why will with won wouldn you your yours yourself yourselves
+aber alle allem allen aller alles als also ander andere anderem anderen anderer anderes anders auch auf aus
+bei bin bis bist damit dann das dass dein deine deinem deinen deiner deines dem den denn der derer des dessen
+dich die dies diese diesem diesen dieser dieses dir doch dort durch ein eine einem einen einer eines einige
+einigem einigen einiger einiges einmal etwas euch euer eure eurem euren eurer eures für gegen gewesen habe haben
+hat hatte hatten hier hin hinter ich ihm ihn ihnen ihr ihre ihrem ihren ihrer ihres indem ins ist jede jedem
+jeden jeder jedes jene jenem jenen jener jenes jetzt kann kein keine keinem keinen keiner keines können könnte
+man manche manchem manchen mancher manches mein meine meinem meinen meiner meines mich mir mit muss musste nach
+nicht nichts noch nun nur oder ohne sehr sein seine seinem seinen seiner seines selbst sich sie sind solche
+solchem solchen solcher solches soll sollte sondern sonst über und uns unser unsere unserem unseren unserer
+unseres unter viel vom von vor während war waren warst was weg weil weiter welche welchem welchen welcher
+welches wenn werde werden wie wieder will wir wird wirst wollen wollte würde würden zum zur zwar zwischen
+`)
+
+// wordSet returns the set of the whitespace-separated words in s.
+func wordSet(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range strings.Fields(s) {
+		set[word] = true
+	}
+	return set
+}
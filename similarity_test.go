@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// topicEmbedder embeds texts by the topics they mention, so texts on the same topic are
+// identical and texts on different topics orthogonal.
+type topicEmbedder []string
+
+func (e topicEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(e))
+		for j, topic := range e {
+			if strings.Contains(text, topic) {
+				vectors[i][j] = 1
+			}
+		}
+	}
+	return vectors, nil
+}
+
+func TestFindSimilar(t *testing.T) {
+	existing := [][]string{{"What is a monitor?", "A lock with conditions."}}
+	notes := []map[string]string{
+		{"Front": "What is a mutex?", "Back": "A lock."},
+		{"Front": "What is a semaphore?", "Back": "A counter."},
+		{"Front": "Define mutex.", "Back": "A lock for threads."},
+		{"Front": "How does a monitor work?", "Back": "It waits on conditions."},
+	}
+	sim := NewSimilarity(topicEmbedder{"mutex", "semaphore", "monitor"}, 0.9)
+	clusters, similarTo, err := sim.FindSimilar(context.Background(), "Basic", notes, existing)
+	if err != nil {
+		t.Fatalf("FindSimilar: %v", err)
+	}
+	if want := []int{1, 0, 1, 0}; !reflect.DeepEqual(clusters, want) {
+		t.Errorf("clusters = %v, want %v", clusters, want)
+	}
+	if want := []string{"", "", "", "What is a monitor?"}; !reflect.DeepEqual(similarTo, want) {
+		t.Errorf("similar notes in the deck = %q, want %q", similarTo, want)
+	}
+}
+
+func TestTFIDFEmbedder(t *testing.T) {
+	texts := []string{
+		"What is a mutex in operating systems?",
+		"What is the mutex of the operating systems?",
+		"Which river flows through Paris?",
+	}
+	vectors, err := NewTFIDFEmbedder().Embed(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(vectors) != len(texts) {
+		t.Fatalf("got %d vectors for %d texts", len(vectors), len(texts))
+	}
+	// the paraphrases only differ in stop words
+	if sim := cosine(vectors[0], vectors[1]); sim < 0.99 {
+		t.Errorf("similarity of paraphrases = %.2f, want 1", sim)
+	}
+	if sim := cosine(vectors[0], vectors[2]); sim > 0.1 {
+		t.Errorf("similarity of unrelated texts = %.2f, want 0", sim)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// transientError marks an error of an LLM provider that is likely to go away when the
+// request is repeated, such as rate limits, overloaded servers and timeouts.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// Transient reports that retrying the request may succeed.
+func (e *transientError) Transient() bool {
+	return true
+}
+
+// markTransient wraps err in a transientError if it is transient.
+func markTransient(err error) error {
+	if err == nil || !isTransient(err) {
+		return err
+	}
+	return &transientError{err: err}
+}
+
+// isTransient reports whether err is a timeout, a rate limit or a server error.
+func isTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return transientStatus(statusErr.StatusCode)
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return transientStatus(apiErr.Code)
+	}
+	return false
+}
+
+func transientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+// timeoutError is a network error caused by a timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "deadline", err: fmt.Errorf("pages 1-10: %w", context.DeadlineExceeded), want: true},
+		{name: "network timeout", err: &url.Error{Op: "Post", URL: "http://localhost:11434", Err: timeoutError{}}, want: true},
+		{name: "rate limit", err: &StatusError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "server error", err: fmt.Errorf("failed: %w", &StatusError{StatusCode: http.StatusServiceUnavailable}), want: true},
+		{name: "bad request", err: &StatusError{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "gemini overloaded", err: &googleapi.Error{Code: http.StatusServiceUnavailable}, want: true},
+		{name: "gemini invalid argument", err: &googleapi.Error{Code: http.StatusBadRequest}, want: false},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "invalid response", err: errors.New("failed to parse generated notes"), want: false},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("%s: isTransient(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestChunkedLLMMarksTransientErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: &StatusError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{err: &StatusError{StatusCode: http.StatusUnauthorized}, want: false},
+	}
+	for _, tt := range tests {
+		llm := NewChunkedLLM(&stubLLM{err: tt.err}, ChunkOptions{})
+		_, err := llm.GenerateAnkiNotes(context.Background(), strings.NewReader("%PDF-1.4"), "Basic")
+		var transient interface{ Transient() bool }
+		if got := errors.As(err, &transient) && transient.Transient(); got != tt.want {
+			t.Errorf("error %v marked transient = %v, want %v", err, got, tt.want)
+		}
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) {
+			t.Errorf("error %v does not wrap the StatusError", err)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package ui
+
+import "regexp"
+
+// clozePattern matches a cloze deletion such as {{c1::answer}} or {{c1::answer::hint}}.
+var clozePattern = regexp.MustCompile(`\{\{c\d+::(.*?)(?:::(.*?))?\}\}`)
+
+// blankCloze replaces every cloze deletion in text with "[...]", or "[hint]" if the
+// deletion has a hint, the way Anki shows the front of a cloze card.
+func blankCloze(text string) string {
+	return clozePattern.ReplaceAllStringFunc(text, func(s string) string {
+		m := clozePattern.FindStringSubmatch(s)
+		if m[2] != "" {
+			return "[" + m[2] + "]"
+		}
+		return "[...]"
+	})
+}
+
+// revealCloze replaces every cloze deletion in text with its highlighted answer.
+func revealCloze(text string) string {
+	return clozePattern.ReplaceAllStringFunc(text, func(s string) string {
+		return clozeStyle.Render(clozePattern.FindStringSubmatch(s)[1])
+	})
+}
@@ -0,0 +1,18 @@
+package ui
+
+import "testing"
+
+func TestBlankCloze(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{text: "The capital of France is {{c1::Paris}}.", want: "The capital of France is [...]."},
+		{text: "{{c1::Go}} was released in {{c2::2009::year}}.", want: "[...] was released in [year]."},
+		{text: "No deletion here.", want: "No deletion here."},
+	}
+	for _, tt := range tests {
+		if got := blankCloze(tt.text); got != tt.want {
+			t.Errorf("blankCloze(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package ui
+
+import (
+	"regexp"
+	"strings"
+)
+
+// noteMatcher returns a function reporting whether the front or back of a note matches
+// query, ignoring case. With regex, query is a regular expression, otherwise plain text.
+func noteMatcher(query string, regex bool) (func(NoteItem) bool, error) {
+	if !regex {
+		q := strings.ToLower(query)
+		return func(it NoteItem) bool {
+			return strings.Contains(strings.ToLower(it.Front), q) || strings.Contains(strings.ToLower(it.Back), q)
+		}, nil
+	}
+	re, err := regexp.Compile("(?i)" + query)
+	if err != nil {
+		return nil, err
+	}
+	return func(it NoteItem) bool {
+		return re.MatchString(it.Front) || re.MatchString(it.Back)
+	}, nil
+}
+
+// visibleNotes returns the list positions of the notes matching the search filter. An
+// empty or invalid filter matches all notes.
+func (m *Model) visibleNotes() []int {
+	match, err := noteMatcher(m.search, m.searchRegex)
+	visible := make([]int, 0, len(m.notes))
+	for i, it := range m.notes {
+		if m.search == "" || err != nil || match(it) {
+			visible = append(visible, i)
+		}
+	}
+	return visible
+}
+
+// hasCursor reports whether the cursor is on a note that is shown.
+func (m *Model) hasCursor() bool {
+	for _, i := range m.visibleNotes() {
+		if i == m.cursor {
+			return true
+		}
+	}
+	return false
+}
+
+// moveCursor moves the cursor by delta notes within the shown notes.
+func (m *Model) moveCursor(delta int) {
+	visible := m.visibleNotes()
+	pos := -1
+	for p, i := range visible {
+		if i == m.cursor {
+			pos = p
+		}
+	}
+	if pos < 0 {
+		m.clampCursor()
+		return
+	}
+	pos = min(max(pos+delta, 0), len(visible)-1)
+	m.cursor = visible[pos]
+}
+
+// clampCursor moves the cursor to the first shown note if the note under it is filtered out.
+func (m *Model) clampCursor() {
+	if visible := m.visibleNotes(); len(visible) > 0 && !m.hasCursor() {
+		m.cursor = visible[0]
+	}
+}
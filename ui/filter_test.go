@@ -0,0 +1,32 @@
+package ui
+
+import "testing"
+
+func TestNoteMatcher(t *testing.T) {
+	note := NoteItem{Front: "What is a Mutex?", Back: "A lock for threads."}
+	tests := []struct {
+		query   string
+		regex   bool
+		want    bool
+		wantErr bool
+	}{
+		{query: "mutex", want: true},
+		{query: "LOCK", want: true},
+		{query: "semaphore", want: false},
+		{query: "a.lock", want: false},
+		{query: "a.lock", regex: true, want: true},
+		{query: "^what", regex: true, want: true},
+		{query: "^lock", regex: true, want: false},
+		{query: "(", regex: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		match, err := noteMatcher(tt.query, tt.regex)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("noteMatcher(%q, %v) error = %v, want error %v", tt.query, tt.regex, err, tt.wantErr)
+			continue
+		}
+		if err == nil && match(note) != tt.want {
+			t.Errorf("noteMatcher(%q, %v) matches = %v, want %v", tt.query, tt.regex, !tt.want, tt.want)
+		}
+	}
+}
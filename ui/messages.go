@@ -1,20 +1,114 @@
 package ui
 
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
 // Messages used by the TUI to communicate async results.
 
+// generatedNotesMsg, generateProgressMsg and generateErrMsg belong to the generation with
+// the given ID, see Model.startOperation.
 type generatedNotesMsg struct {
-	Notes []map[string]string
+	ID        int
+	Notes     []map[string]string
+	NoteModel string
+}
+
+// generateProgressMsg reports that Done of Total chunks have been generated; ch delivers
+// the next message of the same generation.
+type generateProgressMsg struct {
+	ID    int
+	Done  int
+	Total int
+	ch    <-chan tea.Msg
+}
+
+// similarMsg carries the near-duplicate clusters of the notes and the similar notes in the deck.
+type similarMsg struct {
+	// CheckID is the checkID of the model when the check was started.
+	CheckID   int
+	DeckName  string
+	NoteModel string
+	Clusters  []int
+	SimilarTo []string
+	Err       error
+}
+
+// refinedNoteMsg carries the refined version of the note at Index, which was Original
+// when the refinement was started.
+type refinedNoteMsg struct {
+	ID        int
+	Index     int
+	NoteModel string
+	Original  map[string]string
+	Notes     []map[string]string
+	Err       error
+}
+
+// retryMsg triggers the automatic retry of a failed generation scheduled for At.
+type retryMsg struct {
+	At time.Time
 }
 
 type generateErrMsg struct {
+	ID  int
 	Err error
 }
 
 type ankiResultMsg struct {
-	Err error
+	// NotesID is the notesID of the model when the notes were sent.
+	NotesID int
+	// NewDeck is the deck created before adding the notes, if one was.
+	NewDeck string
+	Indices []int
+	IDs     []int64
+	Err     error
+}
+
+// connectedMsg reports the result of connecting to Anki with the decks and the fields of
+// NoteModel, which are nil if they could not be loaded, and why the note types could not
+// be reloaded, if they could not.
+type connectedMsg struct {
+	Decks        []string
+	NoteModel    string
+	Fields       []string
+	NoteTypesErr error
+	Err          error
 }
 
 type deckCreatedMsg struct {
 	DeckName string
 	Err      error
 }
+
+type noteModelsMsg struct {
+	Names []string
+	Err   error
+}
+
+type noteFieldsMsg struct {
+	ModelName string
+	Fields    []string
+	Err       error
+}
+
+// deckNotesMsg carries the existing notes of the deck, which the duplicate check and the
+// search for near-duplicates are run against.
+type deckNotesMsg struct {
+	// CheckID is the checkID of the model when the check was started.
+	CheckID  int
+	DeckName string
+	Notes    [][]string
+	Err      error
+}
+
+type duplicatesMsg struct {
+	// CheckID is the checkID of the model when the check was started.
+	CheckID   int
+	DeckName  string
+	NoteModel string
+	Status    []string
+	Err       error
+}
@@ -2,13 +2,20 @@ package ui
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"maps"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/filepicker"
 	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
@@ -20,10 +27,67 @@ type LLM interface {
 	Close() error
 }
 
+// ProgressLLM is implemented by LLMs that generate in several chunks, can be restricted
+// to page ranges such as "45-78,90" and report their progress.
+type ProgressLLM interface {
+	GenerateAnkiNotesProgress(ctx context.Context, r io.Reader, noteModel, pages string, progress func(done, total int)) ([]map[string]string, error)
+}
+
+// Refiner is implemented by LLMs that can rewrite a single note following an instruction.
+// Several notes are returned if the instruction asks to split the note.
+type Refiner interface {
+	RefineNote(ctx context.Context, noteModel string, note map[string]string, instruction string) ([]map[string]string, error)
+}
+
+// TransientError is implemented by errors of the LLM that may go away when the generation
+// is retried, such as rate limits and timeouts.
+type TransientError interface {
+	Transient() bool
+}
+
+// NoteErrors is implemented by errors of AnkiAPI.AddNotes that carry the reason for every
+// rejected note, with nil entries for the notes that were added.
+type NoteErrors interface {
+	NoteErrors() []error
+}
+
+// DuplicateError is implemented by errors of AnkiAPI reporting that a note already exists
+// in the deck.
+type DuplicateError interface {
+	DuplicateNote() bool
+}
+
+// MissingDeckError is implemented by errors of AnkiAPI reporting that the deck does not
+// exist.
+type MissingDeckError interface {
+	MissingDeck() bool
+}
+
+// SimilarityFinder finds near-duplicates among generated notes and in the existing notes
+// of the target deck, as returned by AnkiAPI.DeckNotes. It returns a cluster ID for every
+// note, shared by notes that are near-duplicates of each other and 0 for the rest, and the
+// first field of a similar existing note or "".
+type SimilarityFinder interface {
+	FindSimilar(ctx context.Context, modelName string, notes []map[string]string, existing [][]string) ([]int, []string, error)
+}
+
 type AnkiAPI interface {
-	AddNotes(deckName, modelName string, notes []map[string]string) error
-	ListDeckNames() ([]string, error)
-	CreateDeck(deckName string) error
+	// Handshake checks that AnkiConnect is reachable, grants access and is recent enough.
+	Handshake(ctx context.Context) error
+	// AddNotes returns the ID of every added note, 0 for notes that were not added. If some
+	// notes were rejected, the error implements NoteErrors.
+	AddNotes(ctx context.Context, deckName, modelName string, notes []map[string]string) ([]int64, error)
+	// CreateDeckAndAddNotes works like AddNotes, but creates the deck in the same request.
+	CreateDeckAndAddNotes(ctx context.Context, deckName, modelName string, notes []map[string]string) ([]int64, error)
+	ListDeckNames(ctx context.Context) ([]string, error)
+	CreateDeck(ctx context.Context, deckName string) error
+	ModelNames(ctx context.Context) ([]string, error)
+	ModelFieldNames(ctx context.Context, modelName string) ([]string, error)
+	// DeckNotes returns the field values of every note in the deck, first field first.
+	DeckNotes(ctx context.Context, deckName string) ([][]string, error)
+	// CheckDuplicates returns "duplicate", "similar" or "" for every note, given the
+	// existing notes of the deck as returned by DeckNotes.
+	CheckDuplicates(ctx context.Context, deckName, modelName string, notes []map[string]string, existing [][]string) ([]string, error)
 }
 
 type AppState int
@@ -33,6 +97,20 @@ const (
 	StateViewingNotes
 	StateSelectingDeck
 	StateCreatingDeck
+	StateSelectingNoteModel
+	StateEnteringPages
+	StateEditingNote
+	StateRefiningNote
+	StateReviewingRefinement
+	StateSearchingNotes
+	StateError
+)
+
+// Automatic retries of generations that failed with a transient error wait
+// retryBaseDelay, doubling with every attempt, up to maxAutoRetries times.
+const (
+	maxAutoRetries = 3
+	retryBaseDelay = 2 * time.Second
 )
 
 // NoteItem represents a generated Anki note.
@@ -41,6 +119,16 @@ type NoteItem struct {
 	Front string
 	Back  string
 	Raw   map[string]string
+	// Duplicate is "duplicate" or "similar" if the target deck already contains the note.
+	Duplicate string
+	// Added is set once the note has been added to Anki.
+	Added bool
+	// AddErr is the reason why Anki rejected the note, if it did.
+	AddErr string
+	// Cluster groups generated notes that are near-duplicates of each other; 0 means none.
+	Cluster int
+	// SimilarTo is the first field of a near-duplicate note in the target deck, if any.
+	SimilarTo string
 }
 
 // Model is the Bubble Tea model for the UI.
@@ -50,28 +138,119 @@ type Model struct {
 	width        int
 	height       int
 	pdfPath      string
+	pages        string
+	pagesInput   textinput.Model
 	pdfList      []string
 	picker       filepicker.Model
 	noteModel    string
+	noteFields   []string
+	modelList    []string
+	modelCursor  int
+	modelFields  map[string][]string
 	deckName     string
 	deckList     []string
 	deckCursor   int
 	newDeckInput textinput.Model
-	notes        []NoteItem
-	selected     map[int]bool
-	cursor       int
-	status       string
-	spinner      spinner.Model
-	llm          LLM
-	anki         AnkiAPI
-	err          error
-	loading      bool
-	search       string
-	state        AppState
-}
-
-// NewModel constructs a UI Model. Provide llm and anki implementations.
-func NewModel(ctx context.Context, llm LLM, anki AnkiAPI) *Model {
+	// addAfterCreate adds the selected notes to the deck being created in the same request.
+	addAfterCreate bool
+	notes          []NoteItem
+	// notesID changes whenever notes is replaced, so results that refer to notes by index
+	// can tell whether they are stale.
+	notesID int
+	// checkID changes whenever the duplicate check is restarted, so the results of an
+	// earlier check are dropped.
+	checkID     int
+	listOffset  int
+	preview     viewport.Model
+	previewNote int
+	editor      textarea.Model
+	editField   int
+	editValues  []string
+	// editIndex and editNotesID identify the note being edited, so edits of a note that
+	// has been replaced in the meantime are dropped.
+	editIndex   int
+	editNotesID int
+	refineInput textinput.Model
+	refineIndex int
+	refined     []map[string]string
+	selected    map[int]bool
+	cursor      int
+	status      string
+	progress    string
+	spinner     spinner.Model
+	llm         LLM
+	anki        AnkiAPI
+	ankiErr     error
+	connecting  bool
+	// connChecked is set if the connection was checked before the UI started, so the
+	// first connect does not repeat the handshake.
+	connChecked   bool
+	loadNoteTypes func(ctx context.Context) error
+	similarity    SimilarityFinder
+	clozeModel    func(noteModel string) bool
+	validNotes    func(noteModel string, notes []map[string]string) ([]map[string]string, int)
+	// dropped is the number of malformed notes left out of the last generation.
+	dropped     int
+	err         error
+	opID        int
+	opCancel    context.CancelFunc
+	opRestore   func() tea.Cmd
+	retries     int
+	retryAt     time.Time
+	loading     bool
+	search      string
+	searchRegex bool
+	searchInput textinput.Model
+	state       AppState
+}
+
+// Option configures optional features of the Model.
+type Option func(*Model)
+
+// WithSimilarity enables near-duplicate detection with the given finder.
+func WithSimilarity(s SimilarityFinder) Option {
+	return func(m *Model) {
+		m.similarity = s
+	}
+}
+
+// WithClozeModels tells which note types are cloze note types, whose first field is shown
+// with the deletions blanked. Without it, only the built-in "Cloze" is.
+func WithClozeModels(isCloze func(noteModel string) bool) Option {
+	return func(m *Model) {
+		m.clozeModel = isCloze
+	}
+}
+
+// WithNoteValidator sets the function that leaves out generated notes unusable for the
+// note type and returns how many it dropped. Without it, every generated note is shown.
+func WithNoteValidator(valid func(noteModel string, notes []map[string]string) ([]map[string]string, int)) Option {
+	return func(m *Model) {
+		m.validNotes = valid
+	}
+}
+
+// WithConnection passes the result of checking the connection to Anki before the UI
+// started, so Init does not check it again.
+func WithConnection(err error) Option {
+	return func(m *Model) {
+		m.connChecked = true
+		m.ankiErr = err
+		m.connecting = err == nil
+	}
+}
+
+// WithNoteTypeLoader sets the function that reloads the note types of Anki after it has
+// been reconnected, as they cannot be loaded while it is not running.
+func WithNoteTypeLoader(load func(ctx context.Context) error) Option {
+	return func(m *Model) {
+		m.loadNoteTypes = load
+	}
+}
+
+// NewModel constructs a UI Model. Provide llm and anki implementations and the note
+// model ("Basic" or "Cloze") the notes are generated for.
+func NewModel(ctx context.Context, llm LLM, anki AnkiAPI, noteModel string, opts ...Option) *Model {
 	cctx, cancel := context.WithCancel(ctx)
 	sp := spinner.New()
 	sp.Spinner = spinner.Dot
@@ -80,35 +259,57 @@ func NewModel(ctx context.Context, llm LLM, anki AnkiAPI) *Model {
 	fp.AllowedTypes = []string{".pdf"}
 	fp.DirAllowed = false
 	fp.FileAllowed = true
+	fp.AutoHeight = false
 	if wd, err := os.Getwd(); err == nil {
 		fp.CurrentDirectory = wd
 	}
 
-	deckNames, _ := anki.ListDeckNames()
-	var deck = "Default"
-
-	if len(deckNames) > 0 {
-		deck = deckNames[0]
-	}
+	fields := defaultFields(noteModel)
 
 	ti := textinput.New()
 	ti.Placeholder = "New deck name"
 
-	return &Model{
+	pi := textinput.New()
+	pi.Placeholder = "all pages, or e.g. 45-78,90"
+
+	si := textinput.New()
+	si.Prompt = "/"
+	si.Placeholder = "filter front and back"
+
+	ri := textinput.New()
+	ri.Placeholder = "e.g. make it shorter, ask about the why, split into two"
+
+	ta := textarea.New()
+	ta.ShowLineNumbers = false
+	ta.SetWidth(60)
+	ta.SetHeight(6)
+
+	m := &Model{
 		ctx:          cctx,
 		cancel:       cancel,
-		noteModel:    "Basic",
-		deckName:     deck,
-		deckList:     deckNames,
+		noteModel:    noteModel,
+		noteFields:   fields,
+		modelFields:  map[string][]string{noteModel: fields},
+		deckName:     "Default",
 		deckCursor:   0,
 		newDeckInput: ti,
+		pagesInput:   pi,
+		editor:       ta,
+		preview:      viewport.New(0, 0),
+		refineInput:  ri,
+		searchInput:  si,
 		selected:     map[int]bool{},
 		spinner:      sp,
 		llm:          llm,
 		anki:         anki,
+		connecting:   true,
 		picker:       fp,
 		state:        StatePickingPDF,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
 func (m *Model) setState(newState AppState) tea.Cmd {
@@ -121,6 +322,25 @@ func (m *Model) setState(newState AppState) tea.Cmd {
 		m.newDeckInput.Reset()
 		m.newDeckInput.Focus()
 		return tea.Batch(spinner.Tick, textinput.Blink)
+	case StateEnteringPages:
+		m.pagesInput.SetValue(m.pages)
+		m.pagesInput.Focus()
+		return tea.Batch(spinner.Tick, textinput.Blink)
+	case StateSelectingNoteModel:
+		m.modelCursor = 0
+		return tea.Batch(spinner.Tick, noteModelsCmd(m.ctx, m.anki))
+	case StateSearchingNotes:
+		m.searchInput.SetValue(m.search)
+		m.searchInput.Focus()
+		return tea.Batch(spinner.Tick, textinput.Blink)
+	case StateRefiningNote:
+		m.refineInput.Reset()
+		m.refineInput.Focus()
+		return tea.Batch(spinner.Tick, textinput.Blink)
+	case StateEditingNote:
+		m.editField = 0
+		m.editor.SetValue(m.editValues[0])
+		return tea.Batch(spinner.Tick, m.editor.Focus())
 	case StateViewingNotes:
 	case StateSelectingDeck:
 	}
@@ -128,26 +348,70 @@ func (m *Model) setState(newState AppState) tea.Cmd {
 }
 
 func (m *Model) Init() tea.Cmd {
-	return m.setState(m.state)
+	if m.connChecked && m.ankiErr != nil {
+		return m.setState(m.state)
+	}
+	return tea.Batch(m.setState(m.state), connectCmd(m.ctx, m.anki, m.noteModel, m.connChecked, m.loadNoteTypes))
 }
 
-// helper to convert raw notes to NoteItem
-func notesToItems(raw []map[string]string) []NoteItem {
+// helper to convert raw notes to NoteItem; the first two fields are shown as front and back
+func notesToItems(raw []map[string]string, fields []string) []NoteItem {
 	var out []NoteItem
 	for i, r := range raw {
-		front := r["Front"]
-		back := r["Back"]
+		var front, back string
+		if len(fields) > 0 {
+			front = r[fields[0]]
+		}
+		if len(fields) > 1 {
+			back = r[fields[1]]
+		}
 		out = append(out, NoteItem{Index: i, Front: front, Back: back, Raw: r})
 	}
 	return out
 }
 
-// generateNotesCmd triggers background generation (returns a command)
-func generateNotesCmd(ctx context.Context, llm LLM, path, noteModel string) tea.Cmd {
+// cloze reports whether the current note type is a cloze note type.
+func (m *Model) cloze() bool {
+	if m.clozeModel == nil {
+		return m.noteModel == "Cloze"
+	}
+	return m.clozeModel(m.noteModel)
+}
+
+// droppedStatus returns the status line suffix reporting the malformed notes left out of
+// the last generation, if there were any.
+func (m *Model) droppedStatus() string {
+	if m.dropped == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", dropped %d malformed notes", m.dropped)
+}
+
+// defaultFields returns the fields of the built-in note types, used when Anki cannot be asked.
+func defaultFields(noteModel string) []string {
+	if noteModel == "Cloze" {
+		return []string{"Text", "Back Extra"}
+	}
+	return []string{"Front", "Back"}
+}
+
+// builtinNoteModels are offered in the note type picker when Anki cannot list its note types.
+var builtinNoteModels = []string{"Basic", "Cloze"}
+
+// generateNotesCmd triggers background generation (returns a command). LLMs implementing
+// ProgressLLM report their progress through generateProgressMsg and bound their own requests;
+// page ranges are only supported by them.
+func generateNotesCmd(ctx context.Context, llm LLM, id int, path, noteModel, pages string) tea.Cmd {
+	if pl, ok := llm.(ProgressLLM); ok {
+		return generateWithProgressCmd(ctx, pl, id, path, noteModel, pages)
+	}
 	return func() tea.Msg {
+		if pages != "" {
+			return generateErrMsg{ID: id, Err: fmt.Errorf("page ranges are not supported by this LLM")}
+		}
 		f, err := os.Open(path)
 		if err != nil {
-			return generateErrMsg{err}
+			return generateErrMsg{ID: id, Err: err}
 		}
 		defer f.Close()
 		// use a short timeout for safety
@@ -155,30 +419,169 @@ func generateNotesCmd(ctx context.Context, llm LLM, path, noteModel string) tea.
 		defer cancel()
 		notes, err := llm.GenerateAnkiNotes(cctx, f, noteModel)
 		if err != nil {
-			return generateErrMsg{err}
+			return generateErrMsg{ID: id, Err: err}
+		}
+		return generatedNotesMsg{ID: id, Notes: notes, NoteModel: noteModel}
+	}
+}
+
+// addNotesCmd triggers add-to-anki, creating the deck first if createDeck is set; indices
+// identifies the notes in the model's list
+func addNotesCmd(ctx context.Context, anki AnkiAPI, notesID int, deck, model string, createDeck bool, indices []int, notes []map[string]string) tea.Cmd {
+	return func() tea.Msg {
+		add, newDeck := anki.AddNotes, ""
+		if createDeck {
+			add, newDeck = anki.CreateDeckAndAddNotes, deck
+		}
+		ids, err := add(ctx, deck, model, notes)
+		var ne NoteErrors
+		if err != nil && !errors.As(err, &ne) {
+			// the deck has not been created if no note was even tried
+			newDeck = ""
 		}
-		return generatedNotesMsg{notes}
+		return ankiResultMsg{NotesID: notesID, NewDeck: newDeck, Indices: indices, IDs: ids, Err: err}
+	}
+}
+
+// generateWithProgressCmd runs the generation in the background and forwards progress
+// updates and the final result over a channel, which is read by waitForGenerationCmd.
+func generateWithProgressCmd(ctx context.Context, llm ProgressLLM, id int, path, noteModel, pages string) tea.Cmd {
+	return func() tea.Msg {
+		ch := make(chan tea.Msg)
+		go func() {
+			defer close(ch)
+			f, err := os.Open(path)
+			if err != nil {
+				ch <- generateErrMsg{ID: id, Err: err}
+				return
+			}
+			defer f.Close()
+			notes, err := llm.GenerateAnkiNotesProgress(ctx, f, noteModel, pages, func(done, total int) {
+				ch <- generateProgressMsg{ID: id, Done: done, Total: total, ch: ch}
+			})
+			if err != nil {
+				ch <- generateErrMsg{ID: id, Err: err}
+				return
+			}
+			ch <- generatedNotesMsg{ID: id, Notes: notes, NoteModel: noteModel}
+		}()
+		return <-ch
+	}
+}
+
+// waitForGenerationCmd waits for the next message of a running generation
+func waitForGenerationCmd(ch <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-ch
+	}
+}
+
+// noteModelsCmd loads the note types available in Anki
+func noteModelsCmd(ctx context.Context, anki AnkiAPI) tea.Cmd {
+	return func() tea.Msg {
+		names, err := anki.ModelNames(ctx)
+		return noteModelsMsg{Names: names, Err: err}
+	}
+}
+
+// noteFieldsCmd loads the fields of a note type
+func noteFieldsCmd(ctx context.Context, anki AnkiAPI, modelName string) tea.Cmd {
+	return func() tea.Msg {
+		fields, err := anki.ModelFieldNames(ctx, modelName)
+		return noteFieldsMsg{ModelName: modelName, Fields: fields, Err: err}
+	}
+}
+
+// deckNotesCmd fetches the existing notes of the deck for the duplicate check
+func deckNotesCmd(ctx context.Context, anki AnkiAPI, checkID int, deck string) tea.Cmd {
+	return func() tea.Msg {
+		notes, err := anki.DeckNotes(ctx, deck)
+		return deckNotesMsg{CheckID: checkID, DeckName: deck, Notes: notes, Err: err}
+	}
+}
+
+// checkDuplicatesCmd checks the notes against the existing notes of the deck
+func checkDuplicatesCmd(ctx context.Context, anki AnkiAPI, checkID int, deck, model string, notes []NoteItem, existing [][]string) tea.Cmd {
+	raw := make([]map[string]string, len(notes))
+	for i, n := range notes {
+		raw[i] = n.Raw
+	}
+	return func() tea.Msg {
+		status, err := anki.CheckDuplicates(ctx, deck, model, raw, existing)
+		return duplicatesMsg{CheckID: checkID, DeckName: deck, NoteModel: model, Status: status, Err: err}
+	}
+}
+
+// findSimilarCmd clusters near-duplicate notes and matches them against the existing notes of the deck
+func findSimilarCmd(ctx context.Context, s SimilarityFinder, checkID int, deck, model string, notes []NoteItem, existing [][]string) tea.Cmd {
+	raw := make([]map[string]string, len(notes))
+	for i, n := range notes {
+		raw[i] = n.Raw
+	}
+	return func() tea.Msg {
+		clusters, similarTo, err := s.FindSimilar(ctx, model, raw, existing)
+		return similarMsg{CheckID: checkID, DeckName: deck, NoteModel: model, Clusters: clusters, SimilarTo: similarTo, Err: err}
 	}
 }
 
-// addNotesCmd triggers add-to-anki
-func addNotesCmd(anki AnkiAPI, deck, model string, notes []map[string]string) tea.Cmd {
+// refineNoteCmd asks the LLM to rewrite the note at index following instruction
+func refineNoteCmd(ctx context.Context, refiner Refiner, id int, noteModel string, index int, note map[string]string, instruction string) tea.Cmd {
 	return func() tea.Msg {
-		err := anki.AddNotes(deck, model, notes)
-		return ankiResultMsg{err}
+		notes, err := refiner.RefineNote(ctx, noteModel, note, instruction)
+		return refinedNoteMsg{ID: id, Index: index, NoteModel: noteModel, Original: note, Notes: notes, Err: err}
+	}
+}
+
+// retryCmd fires a retryMsg at the given time
+func retryCmd(at time.Time) tea.Cmd {
+	return tea.Tick(time.Until(at), func(time.Time) tea.Msg {
+		return retryMsg{At: at}
+	})
+}
+
+// connectCmd checks the connection to Anki unless it has been checked already, reloads the
+// note types with loadNoteTypes, if not nil, after checking it and loads the decks and the
+// fields of noteModel
+func connectCmd(ctx context.Context, anki AnkiAPI, noteModel string, checked bool, loadNoteTypes func(context.Context) error) tea.Cmd {
+	return func() tea.Msg {
+		var noteTypesErr error
+		if !checked {
+			if err := anki.Handshake(ctx); err != nil {
+				return connectedMsg{Err: err}
+			}
+			if loadNoteTypes != nil {
+				noteTypesErr = loadNoteTypes(ctx)
+			}
+		}
+		decks, err := anki.ListDeckNames(ctx)
+		if err != nil {
+			return connectedMsg{Err: err}
+		}
+		fields, err := anki.ModelFieldNames(ctx, noteModel)
+		if err != nil {
+			fields = nil
+		}
+		return connectedMsg{Decks: decks, NoteModel: noteModel, Fields: fields, NoteTypesErr: noteTypesErr}
 	}
 }
 
 // createDeckCmd triggers deck creation in Anki
-func createDeckCmd(anki AnkiAPI, deckName string) tea.Cmd {
+func createDeckCmd(ctx context.Context, anki AnkiAPI, deckName string) tea.Cmd {
 	return func() tea.Msg {
-		err := anki.CreateDeck(deckName)
+		err := anki.CreateDeck(ctx, deckName)
 		return deckCreatedMsg{DeckName: deckName, Err: err}
 	}
 }
 
-// Update processes incoming messages and key events
+// Update processes incoming messages and key events and lays out the notes for the
+// resulting state, so that View only renders.
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	model, cmd := m.update(msg)
+	m.layoutNotes()
+	return model, cmd
+}
+
+func (m *Model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
 	switch mt := msg.(type) {
@@ -186,7 +589,37 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		var cmd tea.Cmd
 		m.spinner, cmd = m.spinner.Update(mt)
 		cmds = append(cmds, cmd)
+	case tea.WindowSizeMsg:
+		m.width = mt.Width
+		m.height = mt.Height
+		m.resize()
+		return m, nil
+	case connectedMsg:
+		m.connecting = false
+		m.ankiErr = mt.Err
+		m.resize()
+		if mt.Err != nil {
+			return m, nil
+		}
+		if mt.NoteTypesErr != nil {
+			m.status = "could not load note types from Anki, using built-in ones: " + mt.NoteTypesErr.Error()
+		}
+		m.deckList = mt.Decks
+		if len(mt.Decks) > 0 && !slices.Contains(mt.Decks, m.deckName) {
+			m.deckName = mt.Decks[0]
+		}
+		if len(mt.Fields) > 0 {
+			m.modelFields[mt.NoteModel] = mt.Fields
+			if mt.NoteModel == m.noteModel && len(m.notes) == 0 {
+				m.noteFields = mt.Fields
+			}
+		}
+		return m, m.checkDuplicates()
 	case tea.KeyMsg:
+		if mt.String() == "c" && m.ankiErr != nil && !m.connecting && !m.typing() {
+			m.connecting = true
+			return m, connectCmd(m.ctx, m.anki, m.noteModel, false, m.loadNoteTypes)
+		}
 		switch m.state {
 		case StatePickingPDF:
 			nm, cmd := m.handlePickerMsg(mt)
@@ -197,26 +630,166 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m.handleNewDeckCreation(mt)
 		case StateViewingNotes:
 			return m.handleViewingNotes(mt)
+		case StateSelectingNoteModel:
+			return m.handleNoteModelSelection(mt)
+		case StateEnteringPages:
+			return m.handlePagesInput(mt)
+		case StateEditingNote:
+			return m.handleEditingNote(mt)
+		case StateSearchingNotes:
+			return m.handleSearchInput(mt)
+		case StateError:
+			return m.handleError(mt)
+		case StateRefiningNote:
+			return m.handleRefineInput(mt)
+		case StateReviewingRefinement:
+			return m.handleRefinementReview(mt)
 		}
+	case generateProgressMsg:
+		// keep draining the channel of cancelled generations so they can finish
+		if mt.ID == m.opID && m.opCancel != nil && mt.Total > 1 {
+			m.progress = fmt.Sprintf("chunk %d/%d", mt.Done, mt.Total)
+		}
+		return m, waitForGenerationCmd(mt.ch)
 	case generatedNotesMsg:
-		m.loading = false
-		m.status = "generated"
-		m.notes = notesToItems(mt.Notes)
+		if !m.finishOperation(mt.ID) {
+			return m, nil
+		}
+		m.progress = ""
+		m.retries = 0
+		notes := mt.Notes
+		m.dropped = 0
+		if m.validNotes != nil {
+			notes, m.dropped = m.validNotes(mt.NoteModel, notes)
+		}
+		m.status = "generated" + m.droppedStatus()
+		m.notes = notesToItems(notes, m.noteFields)
+		m.notesID++
 		m.selected = map[int]bool{}
 		m.cursor = 0
+		m.clampCursor()
+		return m, m.checkDuplicates()
+	case deckNotesMsg:
+		if mt.CheckID != m.checkID || mt.DeckName != m.deckName {
+			return m, nil
+		}
+		if mt.Err != nil {
+			m.status = "duplicate check failed: " + mt.Err.Error()
+			return m, nil
+		}
+		cmd := checkDuplicatesCmd(m.ctx, m.anki, m.checkID, m.deckName, m.noteModel, m.notes, mt.Notes)
+		if m.similarity == nil {
+			return m, cmd
+		}
+		return m, tea.Batch(cmd, findSimilarCmd(m.ctx, m.similarity, m.checkID, m.deckName, m.noteModel, m.notes, mt.Notes))
+	case duplicatesMsg:
+		if mt.CheckID != m.checkID || mt.DeckName != m.deckName || mt.NoteModel != m.noteModel {
+			return m, nil
+		}
+		if mt.Err != nil {
+			m.status = "duplicate check failed: " + mt.Err.Error()
+			return m, nil
+		}
+		if len(mt.Status) != len(m.notes) {
+			return m, nil
+		}
+		dups := 0
+		for i, s := range mt.Status {
+			m.notes[i].Duplicate = s
+			if s != "" {
+				delete(m.selected, i)
+				dups++
+			}
+		}
+		if dups > 0 {
+			m.status = fmt.Sprintf("%d notes already in %s", dups, m.deckName) + m.droppedStatus()
+		}
 		return m, nil
+	case similarMsg:
+		if mt.CheckID != m.checkID || mt.DeckName != m.deckName || mt.NoteModel != m.noteModel {
+			return m, nil
+		}
+		if mt.Err != nil {
+			m.status = "similarity check failed: " + mt.Err.Error()
+			return m, nil
+		}
+		if len(mt.Clusters) != len(m.notes) || len(mt.SimilarTo) != len(m.notes) {
+			return m, nil
+		}
+		similar, clusters := 0, map[int]bool{}
+		for i := range m.notes {
+			m.notes[i].Cluster = mt.Clusters[i]
+			m.notes[i].SimilarTo = mt.SimilarTo[i]
+			if mt.Clusters[i] != 0 {
+				clusters[mt.Clusters[i]] = true
+			}
+			if mt.SimilarTo[i] != "" {
+				delete(m.selected, i)
+				similar++
+			}
+		}
+		if similar > 0 || len(clusters) > 0 {
+			m.status = fmt.Sprintf("%d notes similar to notes in %s, %d groups of similar notes", similar, m.deckName, len(clusters))
+		}
+		return m, nil
+	case refinedNoteMsg:
+		if !m.finishOperation(mt.ID) {
+			return m, nil
+		}
+		if mt.NoteModel != m.noteModel || mt.Index >= len(m.notes) || !maps.Equal(m.notes[mt.Index].Raw, mt.Original) {
+			// the note has been regenerated or edited in the meantime
+			return m, nil
+		}
+		if mt.Err != nil {
+			m.status = "refine error: " + mt.Err.Error()
+			return m, nil
+		}
+		m.refineIndex = mt.Index
+		m.refined = mt.Notes
+		m.cursor = mt.Index
+		m.status = "review refined note"
+		return m, m.setState(StateReviewingRefinement)
 	case generateErrMsg:
-		m.loading = false
+		if !m.finishOperation(mt.ID) {
+			return m, nil
+		}
+		m.progress = ""
 		m.err = mt.Err
 		m.status = "generation error"
-		return m, nil
+		m.retryAt = time.Time{}
+		if !isTransient(mt.Err) || m.retries >= maxAutoRetries {
+			return m, m.setState(StateError)
+		}
+		delay := retryBaseDelay << m.retries
+		m.retries++
+		m.retryAt = time.Now().Add(delay)
+		return m, tea.Batch(m.setState(StateError), retryCmd(m.retryAt))
+	case retryMsg:
+		// ignore retries that were cancelled or superseded in the meantime
+		if m.state != StateError || !mt.At.Equal(m.retryAt) {
+			return m, nil
+		}
+		return m, m.retryGeneration()
 	case ankiResultMsg:
 		m.loading = false
+		return m, m.applyAddResult(mt)
+	case noteModelsMsg:
+		m.modelList = mt.Names
+		if mt.Err != nil || len(mt.Names) == 0 {
+			m.modelList = builtinNoteModels
+			for _, name := range builtinNoteModels {
+				if _, ok := m.modelFields[name]; !ok {
+					m.modelFields[name] = defaultFields(name)
+				}
+			}
+		}
+		return m, m.loadCursorModelFields()
+	case noteFieldsMsg:
 		if mt.Err != nil {
-			m.status = "anki error: " + mt.Err.Error()
-		} else {
-			m.status = "added to anki"
+			m.status = "could not load fields of " + mt.ModelName + ": " + mt.Err.Error()
+			return m, nil
 		}
+		m.modelFields[mt.ModelName] = mt.Fields
 		return m, nil
 	case deckCreatedMsg:
 		if mt.Err != nil {
@@ -226,7 +799,7 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.deckList = append(m.deckList, mt.DeckName)
 			m.status = "deck created"
 		}
-		return m, m.setState(StateViewingNotes)
+		return m, tea.Batch(m.setState(StateViewingNotes), m.checkDuplicates())
 	default:
 		// Send all other messages (including filepicker internal ones) to the active state handler
 		if m.state == StatePickingPDF {
@@ -237,6 +810,23 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// resize fits the file picker and the editor to the terminal size.
+func (m *Model) resize() {
+	// leave room for the title, the footer and the connection banner
+	m.picker.SetHeight(max(m.height-3-m.bannerRows(), 1))
+	m.editor.SetWidth(max(min(m.width-4, 100), 10))
+}
+
+// typing reports whether the current state takes text input, so keys must not trigger
+// other actions.
+func (m *Model) typing() bool {
+	switch m.state {
+	case StateCreatingDeck, StateEnteringPages, StateEditingNote, StateSearchingNotes, StateRefiningNote:
+		return true
+	}
+	return false
+}
+
 // handleViewingNotes processes key inputs to modify the viewing state of notes or perform actions like selection and regeneration.
 func (m *Model) handleViewingNotes(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
@@ -247,45 +837,104 @@ func (m *Model) handleViewingNotes(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.deckCursor = 0
 		m.status = "select deck"
 		return m, m.setState(StateSelectingDeck)
-	case "up", "k":
-		if m.cursor > 0 {
-			m.cursor--
+	case "n":
+		m.status = "select note type"
+		return m, m.setState(StateSelectingNoteModel)
+	case "/":
+		m.status = ""
+		return m, m.setState(StateSearchingNotes)
+	case "esc":
+		if m.opCancel != nil {
+			return m, m.cancelOperation()
 		}
-	case "down", "j":
-		if m.cursor < len(m.notes)-1 {
-			m.cursor++
+		if m.search != "" {
+			m.search = ""
+			m.status = "filter cleared"
+		}
+	case "e":
+		if !m.hasCursor() || len(m.noteFields) == 0 {
+			return m, nil
+		}
+		// the running generation would replace the note being edited
+		if m.opCancel != nil {
+			m.status = "wait for the generation to finish or cancel it with esc"
+			return m, nil
+		}
+		m.editIndex = m.cursor
+		m.editNotesID = m.notesID
+		raw := m.notes[m.cursor].Raw
+		m.editValues = make([]string, len(m.noteFields))
+		for i, field := range m.noteFields {
+			m.editValues[i] = raw[field]
+		}
+		m.status = "editing note"
+		return m, m.setState(StateEditingNote)
+	case "f":
+		if !m.hasCursor() {
+			return m, nil
+		}
+		if _, ok := m.llm.(Refiner); !ok {
+			m.status = "refining notes is not supported by this LLM"
+			return m, nil
+		}
+		// starting a refinement would cancel the running generation and lose the notes
+		// to restore if it is cancelled
+		if m.opCancel != nil {
+			m.status = "wait for the generation to finish or cancel it with esc"
+			return m, nil
 		}
+		m.refineIndex = m.cursor
+		m.status = "refine note"
+		return m, m.setState(StateRefiningNote)
+	case "up", "k":
+		m.moveCursor(-1)
+	case "down", "j":
+		m.moveCursor(1)
+	case "pgup", "ctrl+u":
+		m.preview.HalfPageUp()
+	case "pgdown", "ctrl+d":
+		m.preview.HalfPageDown()
 	case " ":
+		if !m.hasCursor() {
+			return m, nil
+		}
 		if _, ok := m.selected[m.cursor]; ok {
 			delete(m.selected, m.cursor)
 		} else {
 			m.selected[m.cursor] = true
 		}
 	case "s":
-		if len(m.selected) == len(m.notes) {
-			m.selected = map[int]bool{}
-		} else {
-			for i := range m.notes {
+		// only shown notes are selected; added notes, duplicates and all but the first note of a group of
+		// similar notes are left out and can only be selected individually
+		var fresh []int
+		seen := map[int]bool{}
+		for _, i := range m.visibleNotes() {
+			n := m.notes[i]
+			first := n.Cluster == 0 || !seen[n.Cluster]
+			seen[n.Cluster] = true
+			if !n.Added && n.Duplicate == "" && n.SimilarTo == "" && first {
+				fresh = append(fresh, i)
+			}
+		}
+		allSelected := len(fresh) > 0
+		for _, i := range fresh {
+			allSelected = allSelected && m.selected[i]
+		}
+		for _, i := range fresh {
+			if allSelected {
+				delete(m.selected, i)
+			} else {
 				m.selected[i] = true
 			}
 		}
 	case "a":
-		sel := m.getSelectedNotes()
-		if len(sel) == 0 {
-			m.status = "no notes selected"
-			return m, nil
-		}
-		m.loading = true
-		m.status = "adding to Anki..."
-		return m, addNotesCmd(m.anki, m.deckName, m.noteModel, sel)
+		return m, m.addSelected()
 	case "r":
 		if m.pdfPath == "" {
 			m.status = "no pdf selected"
 			return m, nil
 		}
-		m.loading = true
-		m.status = "regenerating..."
-		return m, generateNotesCmd(m.ctx, m.llm, m.pdfPath, m.noteModel)
+		return m, m.generate("regenerating...", nil)
 	}
 	return m, nil
 }
@@ -308,10 +957,9 @@ func (m *Model) handlePickerMsg(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	if did, path := m.picker.DidSelectFile(msg); did {
 		m.pdfPath = path
-		m.loading = true
-		m.status = "generating notes..."
-		m.state = StateViewingNotes
-		return m, generateNotesCmd(m.ctx, m.llm, m.pdfPath, m.noteModel)
+		m.pages = ""
+		m.status = ""
+		return m, m.setState(StateEnteringPages)
 	}
 
 	if didDisabled, _ := m.picker.DidSelectDisabledFile(msg); didDisabled {
@@ -344,11 +992,379 @@ func (m *Model) handleDeckSelection(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 		m.deckName = selected
 		m.status = "deck changed to " + selected
+		return m, tea.Batch(m.setState(StateViewingNotes), m.checkDuplicates())
+	}
+	return m, nil
+}
+
+// handlePagesInput handles key events when entering the page ranges to generate notes for.
+func (m *Model) handlePagesInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		m.cancel()
+		return m, tea.Quit
+	case "esc":
+		m.pagesInput.Blur()
+		m.status = ""
+		return m, m.setState(StatePickingPDF)
+	case "enter":
+		m.pagesInput.Blur()
+		m.pages = strings.TrimSpace(m.pagesInput.Value())
+		restore := func() tea.Cmd {
+			return m.setState(StateEnteringPages)
+		}
+		return m, tea.Batch(m.setState(StateViewingNotes), m.generate("generating notes...", restore))
+	}
+
+	var cmd tea.Cmd
+	m.pagesInput, cmd = m.pagesInput.Update(msg)
+	return m, cmd
+}
+
+// handleNoteModelSelection handles key events when selecting a note type. Choosing a
+// different type discards the current notes and regenerates them for the new type.
+func (m *Model) handleNoteModelSelection(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "q", "ctrl+c", "esc":
+		m.status = ""
+		return m, m.setState(StateViewingNotes)
+	case "up", "k":
+		if m.modelCursor > 0 {
+			m.modelCursor--
+			return m, m.loadCursorModelFields()
+		}
+	case "down", "j":
+		if m.modelCursor < len(m.modelList)-1 {
+			m.modelCursor++
+			return m, m.loadCursorModelFields()
+		}
+	case "enter":
+		if len(m.modelList) == 0 {
+			return m, nil
+		}
+		selected := m.modelList[m.modelCursor]
+		fields, ok := m.modelFields[selected]
+		if !ok {
+			m.status = "loading fields of " + selected + "..."
+			return m, m.loadCursorModelFields()
+		}
+		if selected == m.noteModel {
+			m.status = ""
+			return m, m.setState(StateViewingNotes)
+		}
+
+		prevModel, prevFields, prevNotes, prevSelected, prevCursor := m.noteModel, m.noteFields, m.notes, m.selected, m.cursor
+		restore := func() tea.Cmd {
+			m.noteModel, m.noteFields, m.notes, m.selected, m.cursor = prevModel, prevFields, prevNotes, prevSelected, prevCursor
+			m.notesID++
+			return nil
+		}
+
+		m.noteModel = selected
+		m.noteFields = fields
+		m.notes = nil
+		m.notesID++
+		m.selected = map[int]bool{}
+		m.cursor = 0
+		if m.pdfPath == "" {
+			m.status = "note type changed to " + selected
+			return m, m.setState(StateViewingNotes)
+		}
+		return m, tea.Batch(m.setState(StateViewingNotes), m.generate("regenerating as "+selected+"...", restore))
+	}
+	return m, nil
+}
+
+// handleEditingNote handles key events when editing the fields of the note under the
+// cursor. tab and shift+tab switch between fields, ctrl+s stores the edits in the note and
+// esc discards them.
+func (m *Model) handleEditingNote(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		m.cancel()
+		return m, tea.Quit
+	case "esc":
+		m.editor.Blur()
+		m.status = "edit discarded"
+		return m, m.setState(StateViewingNotes)
+	case "tab", "shift+tab":
+		m.editValues[m.editField] = m.editor.Value()
+		if msg.String() == "tab" {
+			m.editField = (m.editField + 1) % len(m.editValues)
+		} else {
+			m.editField = (m.editField + len(m.editValues) - 1) % len(m.editValues)
+		}
+		m.editor.SetValue(m.editValues[m.editField])
+		return m, nil
+	case "ctrl+s":
+		m.editValues[m.editField] = m.editor.Value()
+		m.editor.Blur()
+		if !m.saveEdit() {
+			m.status = "the notes were replaced while editing, edit discarded"
+			return m, m.setState(StateViewingNotes)
+		}
+		m.status = "note updated"
+		return m, tea.Batch(m.setState(StateViewingNotes), m.checkDuplicates())
+	}
+
+	var cmd tea.Cmd
+	m.editor, cmd = m.editor.Update(msg)
+	return m, cmd
+}
+
+// handleError handles key events while a failed generation is shown. The generation can be
+// retried, another file picked or, if there are notes from before, the notes shown again.
+func (m *Model) handleError(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "q", "ctrl+c":
+		m.cancel()
+		return m, tea.Quit
+	case "r":
+		m.retries = 0
+		return m, m.retryGeneration()
+	case "p":
+		m.err = nil
+		m.retryAt = time.Time{}
+		m.status = ""
+		return m, m.setState(StatePickingPDF)
+	case "esc":
+		if !m.retryAt.IsZero() {
+			m.retryAt = time.Time{}
+			m.status = "automatic retry cancelled"
+			return m, nil
+		}
+		m.err = nil
+		m.status = ""
+		if len(m.notes) == 0 {
+			return m, m.setState(StatePickingPDF)
+		}
+		return m, m.setState(StateViewingNotes)
+	}
+	return m, nil
+}
+
+// retryGeneration starts the failed generation again.
+func (m *Model) retryGeneration() tea.Cmd {
+	m.err = nil
+	m.retryAt = time.Time{}
+	return tea.Batch(m.setState(StateViewingNotes), m.generate("retrying...", nil))
+}
+
+// generate starts generating notes from the current file, cancelling any running
+// generation or refinement. restore is passed on to startOperation.
+func (m *Model) generate(status string, restore func() tea.Cmd) tea.Cmd {
+	ctx, id := m.startOperation(restore)
+	m.status = status
+	return generateNotesCmd(ctx, m.llm, id, m.pdfPath, m.noteModel, m.pages)
+}
+
+// startOperation cancels a running generation or refinement and returns the context and
+// ID of a new one. restore, if not nil, brings back the state from before the operation
+// when it is cancelled.
+func (m *Model) startOperation(restore func() tea.Cmd) (context.Context, int) {
+	if m.opCancel != nil {
+		m.opCancel()
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.opID++
+	m.opCancel = cancel
+	m.opRestore = restore
+	m.loading = true
+	return ctx, m.opID
+}
+
+// finishOperation releases the operation with the given ID once its result has arrived and
+// reports whether it is still the current one, i.e. neither cancelled nor superseded.
+func (m *Model) finishOperation(id int) bool {
+	if id != m.opID || m.opCancel == nil {
+		return false
+	}
+	m.opCancel()
+	m.opCancel = nil
+	m.opRestore = nil
+	m.loading = false
+	return true
+}
+
+// cancelOperation cancels the running generation or refinement, which deletes files
+// uploaded for it, and restores the state from before it started.
+func (m *Model) cancelOperation() tea.Cmd {
+	m.opCancel()
+	m.opCancel = nil
+	m.loading = false
+	m.progress = ""
+	m.status = "cancelled"
+	restore := m.opRestore
+	m.opRestore = nil
+	if restore == nil {
+		return nil
+	}
+	return restore()
+}
+
+// isTransient reports whether retrying the generation that failed with err may succeed.
+func isTransient(err error) bool {
+	var te TransientError
+	return errors.As(err, &te) && te.Transient() || errors.Is(err, context.DeadlineExceeded)
+}
+
+// handleSearchInput handles key events while typing the filter of the notes list, which is
+// applied as it is typed. ctrl+r switches between plain text and regular expressions.
+func (m *Model) handleSearchInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		m.cancel()
+		return m, tea.Quit
+	case "esc":
+		m.searchInput.Blur()
+		m.search = ""
+		m.status = ""
+		return m, m.setState(StateViewingNotes)
+	case "enter":
+		m.searchInput.Blur()
+		m.status = fmt.Sprintf("%d of %d notes shown", len(m.visibleNotes()), len(m.notes))
+		return m, m.setState(StateViewingNotes)
+	case "ctrl+r":
+		m.searchRegex = !m.searchRegex
+		m.applySearch()
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.searchInput, cmd = m.searchInput.Update(msg)
+	m.search = m.searchInput.Value()
+	m.applySearch()
+	return m, cmd
+}
+
+// applySearch reports an invalid filter and keeps the cursor on a shown note.
+func (m *Model) applySearch() {
+	m.status = ""
+	if _, err := noteMatcher(m.search, m.searchRegex); err != nil {
+		m.status = "invalid regular expression: " + err.Error()
+	}
+	m.clampCursor()
+}
+
+// handleRefineInput handles key events when entering the instruction for refining a note.
+func (m *Model) handleRefineInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		m.cancel()
+		return m, tea.Quit
+	case "esc":
+		m.refineInput.Blur()
+		m.status = ""
+		return m, m.setState(StateViewingNotes)
+	case "enter":
+		instruction := strings.TrimSpace(m.refineInput.Value())
+		if instruction == "" {
+			m.status = "instruction cannot be empty"
+			return m, nil
+		}
+		m.refineInput.Blur()
+		ctx, id := m.startOperation(nil)
+		m.status = "refining note..."
+		note := m.notes[m.refineIndex].Raw
+		return m, tea.Batch(m.setState(StateViewingNotes), refineNoteCmd(ctx, m.llm.(Refiner), id, m.noteModel, m.refineIndex, note, instruction))
+	}
+
+	var cmd tea.Cmd
+	m.refineInput, cmd = m.refineInput.Update(msg)
+	return m, cmd
+}
+
+// handleRefinementReview handles key events while the refined version of a note is shown
+// next to the current one.
+func (m *Model) handleRefinementReview(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		m.cancel()
+		return m, tea.Quit
+	case "enter", "y":
+		m.acceptRefinement()
+		return m, tea.Batch(m.setState(StateViewingNotes), m.checkDuplicates())
+	case "f":
+		m.status = "refine note"
+		return m, m.setState(StateRefiningNote)
+	case "esc", "n":
+		m.refined = nil
+		m.status = "refinement discarded"
 		return m, m.setState(StateViewingNotes)
 	}
 	return m, nil
 }
 
+// acceptRefinement replaces the refined note by the refined version, or versions if it was
+// split, keeping the selection of all notes.
+func (m *Model) acceptRefinement() {
+	i, n := m.refineIndex, len(m.refined)
+	items := notesToItems(m.refined, m.noteFields)
+	notes := append(append(m.notes[:i:i], items...), m.notes[i+1:]...)
+	for j := range notes {
+		notes[j].Index = j
+	}
+
+	selected := map[int]bool{}
+	for j := range m.selected {
+		switch {
+		case j < i:
+			selected[j] = true
+		case j == i:
+			for k := range n {
+				selected[i+k] = true
+			}
+		default:
+			selected[j+n-1] = true
+		}
+	}
+
+	m.notes = notes
+	m.notesID++
+	m.selected = selected
+	m.refined = nil
+	if n > 1 {
+		m.status = fmt.Sprintf("note split into %d notes", n)
+	} else {
+		m.status = "note refined"
+	}
+}
+
+// saveEdit writes the edited field values into the note being edited and reports whether
+// it still exists. The raw note is copied so that provenance and other keys are kept while
+// earlier references stay unchanged.
+func (m *Model) saveEdit() bool {
+	if m.editNotesID != m.notesID || m.editIndex >= len(m.notes) {
+		return false
+	}
+	it := &m.notes[m.editIndex]
+	raw := make(map[string]string, len(it.Raw))
+	for k, v := range it.Raw {
+		raw[k] = v
+	}
+	for i, field := range m.noteFields {
+		raw[field] = m.editValues[i]
+	}
+	edited := notesToItems([]map[string]string{raw}, m.noteFields)[0]
+	it.Raw = raw
+	it.Front = edited.Front
+	it.Back = edited.Back
+	it.AddErr = ""
+	return true
+}
+
+// loadCursorModelFields loads the fields of the note type under the cursor unless they are known.
+func (m *Model) loadCursorModelFields() tea.Cmd {
+	if m.modelCursor >= len(m.modelList) {
+		return nil
+	}
+	name := m.modelList[m.modelCursor]
+	if _, ok := m.modelFields[name]; ok {
+		return nil
+	}
+	return noteFieldsCmd(m.ctx, m.anki, name)
+}
+
 // handleNewDeckCreation handles key events when creating a new deck.
 func (m *Model) handleNewDeckCreation(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
@@ -357,6 +1373,7 @@ func (m *Model) handleNewDeckCreation(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "esc":
 		m.newDeckInput.Blur()
+		m.addAfterCreate = false
 		return m, m.setState(StateViewingNotes)
 	case "enter":
 		deckName := m.newDeckInput.Value()
@@ -364,14 +1381,159 @@ func (m *Model) handleNewDeckCreation(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.status = "deck name cannot be empty"
 			return m, nil
 		}
-		m.status = "creating deck..."
 		m.newDeckInput.Blur()
-		return m, createDeckCmd(m.anki, deckName)
+		if m.addAfterCreate {
+			m.addAfterCreate = false
+			return m, tea.Batch(m.setState(StateViewingNotes), m.addSelectedTo(deckName, true))
+		}
+		m.status = "creating deck..."
+		return m, createDeckCmd(m.ctx, m.anki, deckName)
 	}
 
 	return m, cmd
 }
 
+// addSelected adds the selected notes to the target deck.
+func (m *Model) addSelected() tea.Cmd {
+	return m.addSelectedTo(m.deckName, false)
+}
+
+// addSelectedTo adds the selected notes to the given deck, which is created in the same
+// request if createDeck is set.
+func (m *Model) addSelectedTo(deck string, createDeck bool) tea.Cmd {
+	indices, sel := m.getSelectedNotes()
+	if len(sel) == 0 {
+		m.status = "no notes selected"
+		return nil
+	}
+	m.loading = true
+	m.status = "adding to Anki..."
+	return addNotesCmd(m.ctx, m.anki, m.notesID, deck, m.noteModel, createDeck, indices, sel)
+}
+
+// applyAddResult marks added notes as done and deselects them, while rejected notes stay
+// selected with the reason Anki gave. Notes that turn out to be duplicates are marked as
+// such and deselected. If the deck no longer exists, it offers to create it. If the notes
+// were replaced while adding, the duplicate check marks the ones that were added instead.
+func (m *Model) applyAddResult(res ankiResultMsg) tea.Cmd {
+	if res.NewDeck != "" {
+		m.deckName = res.NewDeck
+		if !slices.Contains(m.deckList, res.NewDeck) {
+			m.deckList = append(m.deckList, res.NewDeck)
+		}
+	}
+	if res.NotesID != m.notesID {
+		added := 0
+		for _, id := range res.IDs {
+			if id != 0 {
+				added++
+			}
+		}
+		m.status = fmt.Sprintf("added %d notes to anki", added)
+		if added == 0 && res.Err != nil {
+			m.status = "anki error: " + res.Err.Error()
+		}
+		return m.checkDuplicates()
+	}
+	var noteErrs []error
+	var ne NoteErrors
+	if errors.As(res.Err, &ne) {
+		noteErrs = ne.NoteErrors()
+	} else if res.Err != nil {
+		if missingDeck(res.Err) {
+			return m.offerDeckCreation()
+		}
+		m.status = "anki error: " + res.Err.Error()
+		return nil
+	}
+	for _, err := range noteErrs {
+		if err != nil && missingDeck(err) {
+			return m.offerDeckCreation()
+		}
+	}
+
+	added, failed, dups := 0, 0, 0
+	for j, i := range res.Indices {
+		if i >= len(m.notes) {
+			continue
+		}
+		if j < len(noteErrs) && noteErrs[j] != nil {
+			var de DuplicateError
+			if errors.As(noteErrs[j], &de) && de.DuplicateNote() {
+				m.notes[i].Duplicate = "duplicate"
+				delete(m.selected, i)
+				dups++
+				continue
+			}
+			m.notes[i].AddErr = noteErrs[j].Error()
+			failed++
+			continue
+		}
+		if j < len(res.IDs) && res.IDs[j] != 0 {
+			m.notes[i].Added = true
+			m.notes[i].AddErr = ""
+			delete(m.selected, i)
+			added++
+		}
+	}
+	switch {
+	case failed > 0:
+		m.status = fmt.Sprintf("added %d notes, %d failed", added, failed)
+	case dups > 0:
+		m.status = fmt.Sprintf("added %d notes, %d already in the deck", added, dups)
+	default:
+		m.status = fmt.Sprintf("added %d notes to anki", added)
+	}
+	return nil
+}
+
+// offerDeckCreation asks for confirmation to create the missing target deck and adds the
+// selected notes once it exists.
+func (m *Model) offerDeckCreation() tea.Cmd {
+	cmd := m.setState(StateCreatingDeck)
+	m.newDeckInput.SetValue(m.deckName)
+	m.newDeckInput.CursorEnd()
+	m.addAfterCreate = true
+	m.status = fmt.Sprintf("deck %q does not exist, press enter to create it", m.deckName)
+	return cmd
+}
+
+// missingDeck reports whether err says that the target deck does not exist.
+func missingDeck(err error) bool {
+	var me MissingDeckError
+	return errors.As(err, &me) && me.MissingDeck()
+}
+
+// checkDuplicates starts a duplicate check of the current notes against the target deck
+// and, if enabled, the search for near-duplicates. Both share the notes of the deck, which
+// are fetched first.
+func (m *Model) checkDuplicates() tea.Cmd {
+	if len(m.notes) == 0 {
+		return nil
+	}
+	for i := range m.notes {
+		m.notes[i].Duplicate = ""
+		m.notes[i].Cluster = 0
+		m.notes[i].SimilarTo = ""
+	}
+	m.checkID++
+	return deckNotesCmd(m.ctx, m.anki, m.checkID, m.deckName)
+}
+
+// clusterMates returns the list positions of the other notes in the cluster of note i.
+func (m *Model) clusterMates(i int) []int {
+	var mates []int
+	if m.notes[i].Cluster == 0 {
+		return nil
+	}
+	for j, n := range m.notes {
+		if j != i && n.Cluster == m.notes[i].Cluster {
+			mates = append(mates, j)
+		}
+	}
+	return mates
+}
+
 // getVisibleDecks returns the list of decks shown in the deck selector.
 func (m *Model) getVisibleDecks() []string {
 	decks := make([]string, len(m.deckList)+1)
@@ -382,11 +1544,16 @@ func (m *Model) getVisibleDecks() []string {
 	return decks
 }
 
-// getSelectedNotes returns a slice of raw note data for all selected notes in the model.
-func (m *Model) getSelectedNotes() []map[string]string {
+// getSelectedNotes returns the indices and raw note data of all selected notes that match
+// the search filter, in list order.
+func (m *Model) getSelectedNotes() ([]int, []map[string]string) {
+	var indices []int
 	var sel []map[string]string
-	for i := range m.selected {
-		sel = append(sel, m.notes[i].Raw)
+	for _, i := range m.visibleNotes() {
+		if m.selected[i] {
+			indices = append(indices, i)
+			sel = append(sel, m.notes[i].Raw)
+		}
 	}
-	return sel
+	return indices, sel
 }
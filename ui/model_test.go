@@ -0,0 +1,676 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// fakeLLM generates the fronts of its batches in turn, one batch per generation.
+type fakeLLM struct {
+	batches [][]string
+	calls   int
+}
+
+func (f *fakeLLM) GenerateAnkiNotes(context.Context, io.Reader, string) ([]map[string]string, error) {
+	fronts := f.batches[min(f.calls, len(f.batches)-1)]
+	f.calls++
+	notes := make([]map[string]string, len(fronts))
+	for i, front := range fronts {
+		notes[i] = map[string]string{"Front": front, "Back": "Answer to " + front}
+	}
+	return notes, nil
+}
+
+func (f *fakeLLM) RefineNote(_ context.Context, _ string, note map[string]string, instruction string) ([]map[string]string, error) {
+	return []map[string]string{{"Front": note["Front"] + " (" + instruction + ")", "Back": note["Back"]}}, nil
+}
+
+func (f *fakeLLM) Close() error {
+	return nil
+}
+
+// fakeAnki keeps the fronts of the notes of every deck in memory.
+type fakeAnki struct {
+	decks      map[string][]string
+	connErr    error
+	handshakes int
+	created    []string
+	// deckNoteFetches counts the calls of DeckNotes.
+	deckNoteFetches int
+}
+
+func newFakeAnki(decks ...string) *fakeAnki {
+	a := &fakeAnki{decks: map[string][]string{}}
+	for _, deck := range decks {
+		a.decks[deck] = nil
+	}
+	return a
+}
+
+type missingDeckErr struct{}
+
+func (missingDeckErr) Error() string     { return "deck was not found" }
+func (missingDeckErr) MissingDeck() bool { return true }
+
+type duplicateErr struct{}
+
+func (duplicateErr) Error() string       { return "cannot create note because it is a duplicate" }
+func (duplicateErr) DuplicateNote() bool { return true }
+
+// noteErrors rejects some of the notes of an AddNotes call.
+type noteErrors []error
+
+func (e noteErrors) Error() string       { return "some notes could not be added" }
+func (e noteErrors) NoteErrors() []error { return e }
+
+// AddNotes rejects notes whose front is in the deck already, like Anki.
+func (a *fakeAnki) AddNotes(_ context.Context, deckName, _ string, notes []map[string]string) ([]int64, error) {
+	if _, ok := a.decks[deckName]; !ok {
+		return nil, missingDeckErr{}
+	}
+	ids := make([]int64, len(notes))
+	errs := make(noteErrors, len(notes))
+	var err error
+	for i, note := range notes {
+		if slices.Contains(a.decks[deckName], note["Front"]) {
+			errs[i] = duplicateErr{}
+			err = errs
+			continue
+		}
+		a.decks[deckName] = append(a.decks[deckName], note["Front"])
+		ids[i] = int64(len(a.decks[deckName]))
+	}
+	return ids, err
+}
+
+func (a *fakeAnki) CreateDeckAndAddNotes(ctx context.Context, deckName, modelName string, notes []map[string]string) ([]int64, error) {
+	a.created = append(a.created, deckName)
+	if _, ok := a.decks[deckName]; !ok {
+		a.decks[deckName] = nil
+	}
+	return a.AddNotes(ctx, deckName, modelName, notes)
+}
+
+func (a *fakeAnki) Handshake(context.Context) error {
+	a.handshakes++
+	return a.connErr
+}
+
+func (a *fakeAnki) ListDeckNames(context.Context) ([]string, error) {
+	var names []string
+	for name := range a.decks {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (a *fakeAnki) CreateDeck(_ context.Context, deckName string) error {
+	a.created = append(a.created, deckName)
+	a.decks[deckName] = nil
+	return nil
+}
+
+func (a *fakeAnki) ModelNames(context.Context) ([]string, error) {
+	return []string{"Basic", "Cloze"}, nil
+}
+
+func (a *fakeAnki) ModelFieldNames(_ context.Context, modelName string) ([]string, error) {
+	return defaultFields(modelName), nil
+}
+
+func (a *fakeAnki) DeckNotes(_ context.Context, deckName string) ([][]string, error) {
+	a.deckNoteFetches++
+	var notes [][]string
+	for _, front := range a.decks[deckName] {
+		notes = append(notes, []string{front, ""})
+	}
+	return notes, nil
+}
+
+func (a *fakeAnki) CheckDuplicates(_ context.Context, _, _ string, notes []map[string]string, existing [][]string) ([]string, error) {
+	status := make([]string, len(notes))
+	for i, note := range notes {
+		for _, values := range existing {
+			if values[0] == note["Front"] {
+				status[i] = "duplicate"
+			}
+		}
+	}
+	return status, nil
+}
+
+// fakeSimilarity reports notes whose front is the first field of an existing note as similar.
+type fakeSimilarity struct{}
+
+func (fakeSimilarity) FindSimilar(_ context.Context, _ string, notes []map[string]string, existing [][]string) ([]int, []string, error) {
+	clusters := make([]int, len(notes))
+	similarTo := make([]string, len(notes))
+	for i, note := range notes {
+		for _, values := range existing {
+			if values[0] == note["Front"] {
+				similarTo[i] = values[0]
+			}
+		}
+	}
+	return clusters, similarTo, nil
+}
+
+// newTestModel returns a model showing the notes generated by llm from a PDF.
+func newTestModel(t *testing.T, llm LLM, anki AnkiAPI, opts ...Option) *Model {
+	t.Helper()
+	pdf := filepath.Join(t.TempDir(), "lecture.pdf")
+	if err := os.WriteFile(pdf, []byte("%PDF-1.4"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewModel(context.Background(), llm, anki, "Basic", append([]Option{WithConnection(nil)}, opts...)...)
+	settle(m, m.Init())
+	m.Update(tea.WindowSizeMsg{Width: 100, Height: 20})
+	m.pdfPath = pdf
+	m.setState(StateViewingNotes)
+	press(m, "r")
+	return m
+}
+
+func key(k string) tea.KeyMsg {
+	switch k {
+	case "enter":
+		return tea.KeyMsg{Type: tea.KeyEnter}
+	case "esc":
+		return tea.KeyMsg{Type: tea.KeyEsc}
+	case "ctrl+s":
+		return tea.KeyMsg{Type: tea.KeyCtrlS}
+	case " ":
+		return tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+}
+
+// press sends the keys to m and processes the results of the commands they trigger.
+func press(m *Model, keys ...string) {
+	for _, k := range keys {
+		_, cmd := m.Update(key(k))
+		settle(m, cmd)
+	}
+}
+
+// settle runs cmd and sends the messages of the model's own commands back to m until no
+// more follow. Messages of the bubbles components are dropped, as some of them tick forever.
+func settle(m *Model, cmd tea.Cmd) {
+	for _, msg := range collect(cmd) {
+		_, next := m.Update(msg)
+		settle(m, next)
+	}
+}
+
+// collect runs cmd and returns the messages of the model's own commands.
+func collect(cmd tea.Cmd) []tea.Msg {
+	if cmd == nil {
+		return nil
+	}
+	switch msg := cmd().(type) {
+	case tea.BatchMsg:
+		var msgs []tea.Msg
+		for _, c := range msg {
+			msgs = append(msgs, collect(c)...)
+		}
+		return msgs
+	case generatedNotesMsg, generateErrMsg, refinedNoteMsg, ankiResultMsg, connectedMsg,
+		deckCreatedMsg, noteModelsMsg, noteFieldsMsg, deckNotesMsg, duplicatesMsg, similarMsg:
+		return []tea.Msg{msg}
+	}
+	return nil
+}
+
+func fronts(m *Model, match func(NoteItem) bool) []string {
+	var fronts []string
+	for _, n := range m.notes {
+		if match(n) {
+			fronts = append(fronts, n.Front)
+		}
+	}
+	return fronts
+}
+
+func TestGenerateMarksDuplicates(t *testing.T) {
+	anki := newFakeAnki("Default")
+	anki.decks["Default"] = []string{"Q2"}
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2", "Q3"}}}, anki)
+
+	if m.state != StateViewingNotes || len(m.notes) != 3 {
+		t.Fatalf("state %v with %d notes, want the 3 generated notes shown", m.state, len(m.notes))
+	}
+	if got := fronts(m, func(n NoteItem) bool { return n.Duplicate != "" }); !slices.Equal(got, []string{"Q2"}) {
+		t.Errorf("duplicates = %q, want Q2", got)
+	}
+}
+
+func TestMalformedNotesAreReported(t *testing.T) {
+	anki := newFakeAnki("Default")
+	anki.decks["Default"] = []string{"Q3"}
+	// the validator drops notes whose front starts with "bad"
+	valid := func(_ string, notes []map[string]string) ([]map[string]string, int) {
+		var out []map[string]string
+		for _, note := range notes {
+			if !strings.HasPrefix(note["Front"], "bad") {
+				out = append(out, note)
+			}
+		}
+		return out, len(notes) - len(out)
+	}
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "bad Q2", "Q3"}}}, anki, WithNoteValidator(valid))
+
+	if got := fronts(m, func(NoteItem) bool { return true }); !slices.Equal(got, []string{"Q1", "Q3"}) {
+		t.Errorf("notes %q, want the malformed note left out", got)
+	}
+	if !strings.Contains(m.status, "dropped 1 malformed notes") {
+		t.Errorf("status %q does not report the malformed note", m.status)
+	}
+}
+
+func TestSelectAllAndAdd(t *testing.T) {
+	anki := newFakeAnki("Default")
+	anki.decks["Default"] = []string{"Q2"}
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2", "Q3"}}}, anki)
+
+	press(m, "s")
+	if got := fronts(m, func(n NoteItem) bool { return m.selected[n.Index] }); !slices.Equal(got, []string{"Q1", "Q3"}) {
+		t.Fatalf("selected %q, want Q1 and Q3", got)
+	}
+	press(m, "a")
+	if got := anki.decks["Default"]; !slices.Equal(got, []string{"Q2", "Q1", "Q3"}) {
+		t.Errorf("deck contains %q, want Q1 and Q3 added", got)
+	}
+	if got := fronts(m, func(n NoteItem) bool { return n.Added }); !slices.Equal(got, []string{"Q1", "Q3"}) {
+		t.Errorf("added %q, want Q1 and Q3", got)
+	}
+	if len(m.selected) != 0 {
+		t.Errorf("selected %v after adding, want none", m.selected)
+	}
+
+	// added notes are not selected again
+	press(m, "s")
+	if len(m.selected) != 0 {
+		t.Errorf("select-all selected %v, want no added notes", m.selected)
+	}
+}
+
+func TestStaleAddResultIsDropped(t *testing.T) {
+	anki := newFakeAnki("Default")
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2"}, {"Q3", "Q1"}}}, anki)
+
+	press(m, " ")
+	_, cmd := m.Update(key("a"))
+	result := collect(cmd)
+	// the notes are regenerated while the first one is being added
+	press(m, "r")
+	for _, msg := range result {
+		_, next := m.Update(msg)
+		settle(m, next)
+	}
+
+	if got := fronts(m, func(n NoteItem) bool { return n.Added }); len(got) != 0 {
+		t.Errorf("regenerated notes %q marked as added", got)
+	}
+	if got := fronts(m, func(n NoteItem) bool { return n.Duplicate != "" }); !slices.Equal(got, []string{"Q1"}) {
+		t.Errorf("duplicates = %q, want the added Q1", got)
+	}
+}
+
+func TestDeckNotesAreFetchedOncePerCheck(t *testing.T) {
+	anki := newFakeAnki("Default")
+	anki.decks["Default"] = []string{"Q2"}
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2"}}}, anki, WithSimilarity(fakeSimilarity{}))
+
+	if anki.deckNoteFetches != 1 {
+		t.Errorf("deck notes fetched %d times, want once", anki.deckNoteFetches)
+	}
+	dups := fronts(m, func(n NoteItem) bool { return n.Duplicate != "" })
+	similar := fronts(m, func(n NoteItem) bool { return n.SimilarTo != "" })
+	if !slices.Equal(dups, []string{"Q2"}) || !slices.Equal(similar, []string{"Q2"}) {
+		t.Errorf("duplicates %q and similar notes %q, want Q2 found by both checks", dups, similar)
+	}
+}
+
+func TestStaleDuplicateCheckIsDropped(t *testing.T) {
+	anki := newFakeAnki("Default")
+	anki.decks["Default"] = []string{"Q1"}
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2"}, {"Q3", "Q4"}}}, anki)
+
+	stale := collect(m.checkDuplicates())
+	// the notes are regenerated before the check finishes
+	press(m, "r")
+	for _, msg := range stale {
+		_, next := m.Update(msg)
+		settle(m, next)
+	}
+
+	if got := fronts(m, func(n NoteItem) bool { return n.Duplicate != "" }); len(got) != 0 {
+		t.Errorf("regenerated notes %q marked as duplicates", got)
+	}
+}
+
+func TestNotesAddedElsewhereAreMarkedAsDuplicates(t *testing.T) {
+	anki := newFakeAnki("Default")
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2"}}}, anki)
+
+	// Q2 is added elsewhere after the duplicate check
+	anki.decks["Default"] = []string{"Q2"}
+	press(m, "s", "a")
+	if got := fronts(m, func(n NoteItem) bool { return n.Added }); !slices.Equal(got, []string{"Q1"}) {
+		t.Errorf("added %q, want Q1", got)
+	}
+	if m.notes[1].Duplicate == "" || m.selected[1] {
+		t.Errorf("rejected note marked %q and selected %v, want a deselected duplicate", m.notes[1].Duplicate, m.selected[1])
+	}
+	if m.status != "added 1 notes, 1 already in the deck" {
+		t.Errorf("status %q, want the counts of added notes and duplicates", m.status)
+	}
+}
+
+func TestAddToMissingDeckCreatesIt(t *testing.T) {
+	anki := newFakeAnki()
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2"}}}, anki)
+
+	press(m, "s", "a")
+	if m.state != StateCreatingDeck || m.newDeckInput.Value() != "Default" {
+		t.Fatalf("state %v with deck %q, want the missing deck offered for creation", m.state, m.newDeckInput.Value())
+	}
+	press(m, "enter")
+	if m.state != StateViewingNotes {
+		t.Errorf("state %v, want the notes shown again", m.state)
+	}
+	if !slices.Equal(anki.created, []string{"Default"}) || !slices.Equal(anki.decks["Default"], []string{"Q1", "Q2"}) {
+		t.Errorf("created decks %q containing %q, want Default with Q1 and Q2", anki.created, anki.decks["Default"])
+	}
+	if !slices.Contains(m.deckList, "Default") {
+		t.Errorf("deck list %q does not contain the created deck", m.deckList)
+	}
+	if got := fronts(m, func(n NoteItem) bool { return n.Added }); len(got) != 2 {
+		t.Errorf("added %q, want both notes", got)
+	}
+}
+
+func TestEditNote(t *testing.T) {
+	anki := newFakeAnki("Default")
+	anki.decks["Default"] = []string{"Q2"}
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q3"}}}, anki)
+
+	press(m, "e")
+	if m.state != StateEditingNote || m.editor.Value() != "Q1" {
+		t.Fatalf("state %v editing %q, want the front of Q1 in the editor", m.state, m.editor.Value())
+	}
+	m.editor.SetValue("Q2")
+	press(m, "ctrl+s")
+	if m.state != StateViewingNotes || m.notes[0].Front != "Q2" || m.notes[0].Raw["Front"] != "Q2" {
+		t.Fatalf("state %v with note %q, want the edited note shown", m.state, m.notes[0].Front)
+	}
+	// the edited note is checked against the deck again
+	if m.notes[0].Duplicate == "" {
+		t.Error("edited note not marked as duplicate")
+	}
+
+	press(m, "e")
+	m.editor.SetValue("discarded")
+	press(m, "esc")
+	if m.notes[0].Front != "Q2" {
+		t.Errorf("note %q after discarding the edit, want Q2", m.notes[0].Front)
+	}
+}
+
+func TestEditIsRefusedWhileGenerating(t *testing.T) {
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2"}, {}}}, newFakeAnki("Default"))
+
+	// start a regeneration, which returns no notes, without waiting for its result
+	_, cmd := m.Update(key("r"))
+	press(m, "e")
+	if m.state != StateViewingNotes || !strings.Contains(m.status, "wait") {
+		t.Fatalf("state %v with status %q, want editing refused", m.state, m.status)
+	}
+	settle(m, cmd)
+	if len(m.notes) != 0 {
+		t.Fatalf("%d notes after the regeneration, want none", len(m.notes))
+	}
+}
+
+func TestEditOfReplacedNotesIsDropped(t *testing.T) {
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2"}}}, newFakeAnki("Default"))
+
+	press(m, "e")
+	m.editor.SetValue("edited")
+	// the notes are replaced by an empty batch while the editor is open
+	_, id := m.startOperation(nil)
+	m.Update(generatedNotesMsg{ID: id, NoteModel: "Basic"})
+	press(m, "ctrl+s")
+	if m.state != StateViewingNotes || len(m.notes) != 0 || !strings.Contains(m.status, "discarded") {
+		t.Errorf("state %v with %d notes and status %q, want the edit discarded", m.state, len(m.notes), m.status)
+	}
+}
+
+func TestRefineNote(t *testing.T) {
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2"}}}, newFakeAnki("Default"))
+
+	press(m, " ", "f")
+	if m.state != StateRefiningNote {
+		t.Fatalf("state %v, want refining", m.state)
+	}
+	m.Update(key("shorter"))
+	press(m, "enter")
+	if m.state != StateReviewingRefinement {
+		t.Fatalf("state %v, want the refinement shown", m.state)
+	}
+	press(m, "enter")
+	if got := fronts(m, func(NoteItem) bool { return true }); !slices.Equal(got, []string{"Q1 (shorter)", "Q2"}) {
+		t.Errorf("notes %q, want Q1 refined", got)
+	}
+	if !m.selected[0] {
+		t.Error("refined note is no longer selected")
+	}
+}
+
+func TestRefineIsRefusedWhileGenerating(t *testing.T) {
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2"}}}, newFakeAnki("Default"))
+
+	// start a regeneration without waiting for its result
+	m.Update(key("r"))
+	press(m, "f")
+	if m.state != StateViewingNotes || !strings.Contains(m.status, "wait") {
+		t.Fatalf("state %v with status %q, want refining refused", m.state, m.status)
+	}
+	press(m, "esc", "f")
+	if m.state != StateRefiningNote {
+		t.Fatalf("state %v after cancelling the generation, want refining", m.state)
+	}
+	m.Update(key("shorter"))
+	press(m, "enter")
+	if m.state != StateReviewingRefinement {
+		t.Fatalf("state %v, want the refinement shown", m.state)
+	}
+	press(m, "enter")
+	if m.notes[0].Front != "Q1 (shorter)" {
+		t.Errorf("refined note %q, want Q1 (shorter)", m.notes[0].Front)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"What is a mutex?", "What is a semaphore?", "Mutex vs. semaphore"}}}, newFakeAnki("Default"))
+
+	press(m, "/", "mutex", "enter")
+	if got := len(m.visibleNotes()); got != 2 {
+		t.Errorf("%d notes shown, want 2", got)
+	}
+	press(m, "/", "esc")
+	if got := len(m.visibleNotes()); got != 3 {
+		t.Errorf("%d notes shown after clearing the filter, want 3", got)
+	}
+}
+
+func TestListScrollsToCursor(t *testing.T) {
+	var batch []string
+	for i := range 30 {
+		batch = append(batch, fmt.Sprintf("Q%d", i))
+	}
+	m := newTestModel(t, &fakeLLM{batches: [][]string{batch}}, newFakeAnki("Default"))
+	for range 20 {
+		press(m, "j")
+	}
+	view := m.View()
+	if !strings.Contains(view, "> [ ] Q20") || strings.Contains(view, "[ ] Q0\n") {
+		t.Errorf("view is not scrolled to the cursor on Q20:\n%s", view)
+	}
+	if lines := strings.Count(view, "\n") + 1; lines > 20 {
+		t.Errorf("view has %d lines, want at most the 20 of the terminal", lines)
+	}
+}
+
+// transientErr is a rate limit error that may go away on a retry.
+type transientErr struct{}
+
+func (transientErr) Error() string   { return "rate limited" }
+func (transientErr) Transient() bool { return true }
+
+func TestTransientErrorsAreRetriedWithBackoff(t *testing.T) {
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2"}}}, newFakeAnki("Default"))
+	// fail lets a new attempt of the generation fail with err
+	fail := func(err error) {
+		m.retryGeneration()
+		m.Update(generateErrMsg{ID: m.opID, Err: err})
+	}
+
+	for attempt, delay := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		fail(transientErr{})
+		if d := time.Until(m.retryAt); m.state != StateError || d <= delay-time.Second || d > delay {
+			t.Fatalf("attempt %d: state %v with retry in %v, want a retry in %v", attempt+1, m.state, d, delay)
+		}
+	}
+	fail(transientErr{})
+	if !m.retryAt.IsZero() {
+		t.Errorf("retry scheduled after %d attempts, want at most %d", m.retries, maxAutoRetries)
+	}
+
+	fail(errors.New("invalid api key"))
+	if !m.retryAt.IsZero() {
+		t.Error("retry scheduled for a permanent error")
+	}
+
+	m.retries = 0
+	fail(transientErr{})
+	// a retry superseded by a later one is ignored
+	m.Update(retryMsg{At: m.retryAt.Add(-time.Second)})
+	if m.state != StateError {
+		t.Fatalf("state %v after a stale retry, want the error still shown", m.state)
+	}
+	_, cmd := m.Update(retryMsg{At: m.retryAt})
+	settle(m, cmd)
+	if m.state != StateViewingNotes || len(m.notes) != 2 || m.retries != 0 {
+		t.Errorf("state %v with %d notes and %d retries, want the notes generated again", m.state, len(m.notes), m.retries)
+	}
+}
+
+func TestCancelGeneration(t *testing.T) {
+	m := newTestModel(t, &fakeLLM{batches: [][]string{{"Q1", "Q2"}, {"Q3"}}}, newFakeAnki("Default"))
+
+	_, cmd := m.Update(key("r"))
+	press(m, "esc")
+	if m.opCancel != nil || m.status != "cancelled" {
+		t.Fatalf("status %q, want the generation cancelled", m.status)
+	}
+	// the result of the cancelled generation arrives late
+	settle(m, cmd)
+	if got := fronts(m, func(NoteItem) bool { return true }); !slices.Equal(got, []string{"Q1", "Q2"}) {
+		t.Errorf("notes %q after cancelling, want the previous notes kept", got)
+	}
+}
+
+func TestConnectOnStartup(t *testing.T) {
+	anki := newFakeAnki("Default", "Lectures")
+	m := NewModel(context.Background(), &fakeLLM{}, anki, "Basic")
+	settle(m, m.Init())
+	if anki.handshakes != 1 || m.ankiErr != nil {
+		t.Errorf("ankiErr %v after %d handshakes, want connected once", m.ankiErr, anki.handshakes)
+	}
+	if !slices.Equal(m.deckList, []string{"Default", "Lectures"}) {
+		t.Errorf("deck list %q, want the decks of Anki", m.deckList)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	anki := newFakeAnki("Default")
+	anki.connErr = errors.New("Anki is not running")
+	m := NewModel(context.Background(), &fakeLLM{}, anki, "Basic")
+	settle(m, m.Init())
+	if m.ankiErr == nil || len(m.deckList) != 0 {
+		t.Fatalf("ankiErr %v with decks %q, want the connection error shown", m.ankiErr, m.deckList)
+	}
+
+	anki.connErr = nil
+	press(m, "c")
+	if m.ankiErr != nil || !slices.Equal(m.deckList, []string{"Default"}) {
+		t.Errorf("ankiErr %v with decks %q after reconnecting, want connected", m.ankiErr, m.deckList)
+	}
+}
+
+func TestStartupConnectionIsReused(t *testing.T) {
+	anki := newFakeAnki("Default", "Lectures")
+	m := NewModel(context.Background(), &fakeLLM{}, anki, "Basic", WithConnection(nil))
+	settle(m, m.Init())
+	if anki.handshakes != 0 {
+		t.Errorf("handshake repeated %d times", anki.handshakes)
+	}
+	if !slices.Equal(m.deckList, []string{"Default", "Lectures"}) {
+		t.Errorf("deck list %q, want the decks of Anki", m.deckList)
+	}
+}
+
+func TestReconnectReloadsNoteTypes(t *testing.T) {
+	anki := newFakeAnki("Default")
+	loads := 0
+	m := NewModel(context.Background(), &fakeLLM{}, anki, "Basic",
+		WithConnection(errors.New("Anki is not running")),
+		WithNoteTypeLoader(func(context.Context) error {
+			loads++
+			return nil
+		}),
+	)
+	settle(m, m.Init())
+	if m.ankiErr == nil || anki.handshakes != 0 || loads != 0 {
+		t.Fatalf("ankiErr %v after %d handshakes and %d loads, want the startup error kept", m.ankiErr, anki.handshakes, loads)
+	}
+
+	press(m, "c")
+	if m.ankiErr != nil || anki.handshakes != 1 || loads != 1 {
+		t.Errorf("ankiErr %v after %d handshakes and %d loads, want connected with note types reloaded", m.ankiErr, anki.handshakes, loads)
+	}
+}
+
+func TestViewHasNoSideEffects(t *testing.T) {
+	var batch []string
+	for i := range 30 {
+		batch = append(batch, fmt.Sprintf("Q%d", i))
+	}
+	m := newTestModel(t, &fakeLLM{batches: [][]string{batch}}, newFakeAnki("Default"))
+	for range 20 {
+		press(m, "j")
+	}
+	offset := m.listOffset
+	if offset == 0 {
+		t.Fatal("list not scrolled to the cursor")
+	}
+	first := m.View()
+	if second := m.View(); first != second || m.listOffset != offset {
+		t.Error("rendering the view twice changed the model")
+	}
+	if !strings.Contains(first, "> [ ] Q20") {
+		t.Errorf("view does not show the cursor on Q20:\n%s", first)
+	}
+}
@@ -3,70 +3,324 @@ package ui
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 )
 
 var (
-	listStyle  = lipgloss.NewStyle().Padding(0, 1)
-	selStyle   = lipgloss.NewStyle().Background(lipgloss.Color("62")).Foreground(lipgloss.Color("230"))
-	titleStyle = lipgloss.NewStyle().Bold(true)
+	listStyle    = lipgloss.NewStyle().Padding(0, 1)
+	selStyle     = lipgloss.NewStyle().Background(lipgloss.Color("62")).Foreground(lipgloss.Color("230"))
+	titleStyle   = lipgloss.NewStyle().Bold(true)
+	clozeStyle   = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("39"))
+	sourceStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	dupStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	errStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	previewStyle = lipgloss.NewStyle().Padding(0, 1)
 )
 
+// listRatio is the share of the terminal width taken by the notes list next to the preview.
+const listRatio = 0.4
+
+// noteRows returns the number of lines available for the notes list and the preview below
+// the title and above the footer, or 0 if the terminal size is not known yet.
+func (m *Model) noteRows() int {
+	if m.height == 0 {
+		return 0
+	}
+	return max(m.height-3-m.bannerRows(), 1)
+}
+
+// bannerRows returns the number of lines taken by the connection banner.
+func (m *Model) bannerRows() int {
+	if m.ankiErr == nil {
+		return 0
+	}
+	return 1
+}
+
+// renderBanner renders the banner shown while Anki is not connected.
+func (m *Model) renderBanner() string {
+	text := "not connected to Anki (c:reconnect): " + m.ankiErr.Error()
+	if m.connecting {
+		text = "reconnecting to Anki..."
+	}
+	style := errStyle.Bold(true)
+	if m.width > 0 {
+		style = style.MaxWidth(m.width)
+	}
+	return style.Render(text)
+}
+
+// columnWidths returns the widths of the notes list and the preview, or zeros if the
+// terminal size is not known yet.
+func (m *Model) columnWidths() (int, int) {
+	if m.width == 0 {
+		return 0, 0
+	}
+	list := int(float64(m.width) * listRatio)
+	return list, m.width - list
+}
+
+// layoutNotes scrolls the notes list so the cursor stays visible and fills the preview with
+// the note under the cursor, starting at its top when the cursor moved to another note.
+func (m *Model) layoutNotes() {
+	if m.state != StateViewingNotes && m.state != StateSearchingNotes {
+		return
+	}
+	visible := m.visibleNotes()
+	pos := m.cursorPosition(visible)
+	rows := m.listRows(visible)
+	if pos < m.listOffset {
+		m.listOffset = pos
+	}
+	if pos >= m.listOffset+rows {
+		m.listOffset = pos - rows + 1
+	}
+	m.listOffset = max(min(m.listOffset, len(visible)-rows), 0)
+
+	_, width := m.columnWidths()
+	if width == 0 || m.noteRows() == 0 {
+		return
+	}
+	m.preview.Width = width
+	m.preview.Height = m.noteRows()
+	m.preview.SetContent(previewStyle.Width(width).Render(m.previewContent()))
+	if m.previewNote != m.cursor {
+		m.preview.GotoTop()
+		m.previewNote = m.cursor
+	}
+}
+
+// cursorPosition returns the position of the cursor among the visible notes.
+func (m *Model) cursorPosition(visible []int) int {
+	for p, i := range visible {
+		if i == m.cursor {
+			return p
+		}
+	}
+	return 0
+}
+
+// listRows returns the number of notes shown in the list at once.
+func (m *Model) listRows(visible []int) int {
+	// one row is taken by the position indicator
+	if r := m.noteRows(); r > 0 {
+		return max(r-1, 1)
+	}
+	return len(visible)
+}
+
 func (m *Model) View() string {
-	if m.err != nil {
-		return fmt.Sprintf("Error: %v\n", m.err)
+	if m.ankiErr != nil {
+		return m.renderBanner() + "\n" + m.view()
 	}
+	return m.view()
+}
 
+func (m *Model) view() string {
 	switch m.state {
+	case StateError:
+		return titleStyle.Render("Error") + "\n" + m.renderError() + "\n" + m.renderFooter()
 	case StatePickingPDF:
 		return titleStyle.Render("Choose PDF") + "\n" + m.picker.View() + "\n" + m.renderFooter()
 	case StateSelectingDeck:
 		return titleStyle.Render("Select Deck") + "\n" + m.renderDeckSelector() + "\n" + m.renderFooter()
 	case StateCreatingDeck:
 		return titleStyle.Render("Create New Deck") + "\n" + m.renderNewDeckInput() + "\n" + m.renderFooter()
-	case StateViewingNotes:
+	case StateEnteringPages:
+		return titleStyle.Render("Select Pages") + "\n" + m.renderPagesInput() + "\n" + m.renderFooter()
+	case StateSelectingNoteModel:
+		cols := lipgloss.JoinHorizontal(lipgloss.Top, m.renderNoteModelSelector(), m.renderNoteModelFields())
+		return titleStyle.Render("Select Note Type") + "\n" + cols + "\n" + m.renderFooter()
+	case StateRefiningNote:
+		return titleStyle.Render("Refine Note") + "\n" + m.renderRefineInput() + "\n" + m.renderFooter()
+	case StateReviewingRefinement:
+		return titleStyle.Render("Review Refined Note") + "\n" + m.renderRefinement() + "\n" + m.renderFooter()
+	case StateEditingNote:
+		return titleStyle.Render("Edit Note") + "\n" + m.renderEditor() + "\n" + m.renderFooter()
+	case StateViewingNotes, StateSearchingNotes:
 		left := m.renderList()
 		right := m.renderPreview()
 		cols := lipgloss.JoinHorizontal(lipgloss.Top, left, right)
 		footer := m.renderFooter()
-		return titleStyle.Render("Select Notes") + "\n" + cols + "\n" + footer
+		return titleStyle.Render("Select Notes") + m.renderSearch() + "\n" + cols + "\n" + footer
 	}
 	return ""
 }
 
+// renderSearch renders the search filter next to the title while it is typed or applied.
+func (m *Model) renderSearch() string {
+	mode := ""
+	if m.searchRegex {
+		mode = " (regex)"
+	}
+	switch {
+	case m.state == StateSearchingNotes:
+		return "  " + m.searchInput.View() + sourceStyle.Render(mode)
+	case m.search != "":
+		return "  " + sourceStyle.Render("/"+m.search+mode)
+	}
+	return ""
+}
+
+// renderList renders the shown notes that fit into the terminal, scrolled by layoutNotes,
+// followed by the position of the cursor.
 func (m *Model) renderList() string {
+	visible := m.visibleNotes()
+	pos := m.cursorPosition(visible)
+	rows := m.listRows(visible)
+	offset := max(min(m.listOffset, len(visible)-rows), 0)
+
+	width, _ := m.columnWidths()
+	line := lipgloss.NewStyle()
+	if width > 0 {
+		// the list style adds one column of padding on each side
+		line = line.MaxWidth(width - 2)
+	}
+
 	var b strings.Builder
-	for i, it := range m.notes {
+	for _, i := range visible[offset:min(offset+rows, len(visible))] {
+		it := m.notes[i]
 		cursor := " "
 		if i == m.cursor {
 			cursor = ">"
 		}
 		chk := "[ ]"
-		if m.selected[i] {
+		switch {
+		case m.selected[i]:
 			chk = "[x]"
+		case it.Added:
+			chk = "[✓]"
 		}
-		line := fmt.Sprintf("%s %s %s", cursor, chk, it.Front)
+		front := strings.ReplaceAll(it.Front, "\n", " ")
+		if m.cloze() {
+			front = blankCloze(front)
+		}
+		badge := ""
+		switch {
+		case it.AddErr != "":
+			badge = errStyle.Render("[!] ")
+		case it.Duplicate == "duplicate":
+			badge = dupStyle.Render("[dup] ")
+		case it.Duplicate == "similar" || it.SimilarTo != "":
+			badge = dupStyle.Render("[~dup] ")
+		}
+		if it.Cluster != 0 {
+			badge += dupStyle.Render(fmt.Sprintf("[≈%d] ", it.Cluster))
+		}
+		text := line.Render(fmt.Sprintf("%s %s %s%s", cursor, chk, badge, front))
 		if i == m.cursor {
-			b.WriteString(selStyle.Render(line) + "\n")
+			b.WriteString(selStyle.Render(text) + "\n")
 		} else {
-			b.WriteString(line + "\n")
+			b.WriteString(text + "\n")
 		}
 	}
-	return listStyle.Render(b.String())
+	if len(visible) > 0 {
+		position := fmt.Sprintf("%d/%d", pos+1, len(visible))
+		if len(visible) < len(m.notes) {
+			position += fmt.Sprintf(" (%d notes)", len(m.notes))
+		}
+		b.WriteString(sourceStyle.Render(position) + "\n")
+	}
+	style := listStyle
+	if width > 0 {
+		style = style.Width(width)
+	}
+	return style.Render(b.String())
 }
 
+// renderPreview renders the note under the cursor wrapped to the width of the preview
+// column. Notes longer than the terminal scroll in the viewport filled by layoutNotes.
 func (m *Model) renderPreview() string {
+	_, width := m.columnWidths()
+	if width == 0 || m.noteRows() == 0 {
+		return previewStyle.Render(m.previewContent())
+	}
+	return m.preview.View()
+}
+
+func (m *Model) previewContent() string {
 	if len(m.notes) == 0 {
 		return "(no notes)"
 	}
+	if !m.hasCursor() {
+		return "(no matching notes)"
+	}
 	cur := m.notes[m.cursor]
 	var b strings.Builder
-	b.WriteString(titleStyle.Render("Front") + "\n")
-	b.WriteString(cur.Front + "\n\n")
-	b.WriteString(titleStyle.Render("Back") + "\n")
-	b.WriteString(cur.Back + "\n")
-	return lipgloss.NewStyle().Padding(0, 1).Render(b.String())
+	if m.cloze() {
+		b.WriteString(titleStyle.Render(m.noteFields[0]) + "\n")
+		b.WriteString(blankCloze(cur.Front) + "\n\n")
+		b.WriteString(titleStyle.Render("Answer") + "\n")
+		b.WriteString(revealCloze(cur.Front) + "\n")
+		for _, field := range m.noteFields[1:] {
+			b.WriteString("\n" + titleStyle.Render(field) + "\n")
+			b.WriteString(cur.Raw[field] + "\n")
+		}
+		b.WriteString(renderSource(cur.Raw))
+		b.WriteString(m.renderSimilar())
+		b.WriteString(renderAddErr(cur))
+		return b.String()
+	}
+	for i, field := range m.noteFields {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(titleStyle.Render(field) + "\n")
+		b.WriteString(cur.Raw[field] + "\n")
+	}
+	b.WriteString(renderSource(cur.Raw))
+	b.WriteString(m.renderSimilar())
+	b.WriteString(renderAddErr(cur))
+	return b.String()
+}
+
+// renderSimilar renders the similar note in the deck and the similar generated notes of the
+// note under the cursor, so the best of them can be kept.
+func (m *Model) renderSimilar() string {
+	cur := m.notes[m.cursor]
+	var b strings.Builder
+	if cur.SimilarTo != "" {
+		b.WriteString("\n" + dupStyle.Render("Similar note in "+m.deckName+": "+cur.SimilarTo) + "\n")
+	}
+	if mates := m.clusterMates(m.cursor); len(mates) > 0 {
+		b.WriteString("\n" + dupStyle.Render(fmt.Sprintf("Similar generated notes (group %d):", cur.Cluster)) + "\n")
+		for _, j := range mates {
+			front := m.notes[j].Front
+			if m.cloze() {
+				front = blankCloze(front)
+			}
+			b.WriteString(fmt.Sprintf("- #%d %s\n", j+1, front))
+		}
+	}
+	return b.String()
+}
+
+// renderAddErr renders why Anki rejected the note, if it did.
+func renderAddErr(it NoteItem) string {
+	if it.AddErr == "" {
+		return ""
+	}
+	return "\n" + errStyle.Render("Not added: "+it.AddErr) + "\n"
+}
+
+// renderSource renders the file, page and section a note was generated from, if known.
+func renderSource(raw map[string]string) string {
+	var parts []string
+	if file := raw["source_file"]; file != "" {
+		parts = append(parts, file)
+	}
+	if page := raw["source_page"]; page != "" {
+		parts = append(parts, "p. "+page)
+	}
+	if section := raw["source_section"]; section != "" {
+		parts = append(parts, section)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "\n" + sourceStyle.Render("Source: "+strings.Join(parts, " · ")) + "\n"
 }
 
 func (m *Model) renderFooter() string {
@@ -75,19 +329,124 @@ func (m *Model) renderFooter() string {
 	case StatePickingPDF:
 		hints = "up/down:move  enter:select  q:quit"
 	case StateViewingNotes:
-		hints = fmt.Sprintf("j/k:move  space:toggle  a:add  s:select-all d:change-deck (%s) r:regenerate  q:quit", m.deckName)
+		if m.opCancel != nil {
+			hints = "esc:cancel  "
+		}
+		hints += fmt.Sprintf("j/k:move  space:toggle  ctrl+d/u:scroll preview  /:filter  e:edit  f:refine  a:add  s:select-all d:change-deck (%s) n:note-type (%s) r:regenerate  q:quit", m.deckName, m.noteModel)
 	case StateSelectingDeck:
 		hints = "j/k:move  enter:select  esc:cancel  q:quit"
 	case StateCreatingDeck:
 		hints = "enter:confirm  esc:cancel  q:quit"
+	case StateSelectingNoteModel:
+		hints = "j/k:move  enter:select and regenerate  esc:cancel"
+	case StateEnteringPages:
+		hints = "enter:generate  esc:back"
+	case StateEditingNote:
+		hints = "tab/shift+tab:switch field  ctrl+s:save  esc:discard"
+	case StateRefiningNote:
+		hints = "enter:refine  esc:cancel"
+	case StateSearchingNotes:
+		hints = "enter:apply  ctrl+r:toggle regex  esc:clear"
+	case StateError:
+		hints = "r:retry  p:pick another file  esc:back to notes  q:quit"
+		if !m.retryAt.IsZero() {
+			hints = "r:retry now  p:pick another file  esc:cancel retry  q:quit"
+		}
+	case StateReviewingRefinement:
+		hints = "enter/y:accept  f:refine again  esc/n:discard"
 	}
 	status := m.status
 	sp := ""
 	if m.loading {
 		status = "working"
+		if m.progress != "" {
+			status += " " + m.progress
+		}
 		sp = " " + m.spinner.View()
 	}
-	return lipgloss.NewStyle().Foreground(lipgloss.Color("241")).Render(hints + "    " + status + sp)
+	style := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	if m.width > 0 {
+		style = style.MaxWidth(m.width)
+	}
+	return style.Render(hints + "    " + status + sp)
+}
+
+func (m *Model) renderRefineInput() string {
+	return lipgloss.NewStyle().Padding(0, 1).Render(m.renderNoteFields(m.notes[m.refineIndex].Raw) + "\nInstruction: " + m.refineInput.View())
+}
+
+// renderRefinement renders the current note and its refined version side by side.
+func (m *Model) renderRefinement() string {
+	current := titleStyle.Render("Current") + "\n\n" + m.renderNoteFields(m.notes[m.refineIndex].Raw)
+	var b strings.Builder
+	for i, note := range m.refined {
+		title := "Refined"
+		if len(m.refined) > 1 {
+			title = fmt.Sprintf("Refined %d/%d", i+1, len(m.refined))
+		}
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(titleStyle.Render(title) + "\n\n" + m.renderNoteFields(note))
+	}
+	width := 50
+	if m.width > 0 {
+		width = m.width / 2
+	}
+	col := lipgloss.NewStyle().Padding(0, 1).Width(width)
+	return lipgloss.JoinHorizontal(lipgloss.Top, col.Render(current), col.Render(b.String()))
+}
+
+// renderNoteFields renders all fields of a raw note with their names.
+func (m *Model) renderNoteFields(raw map[string]string) string {
+	var b strings.Builder
+	for _, field := range m.noteFields {
+		b.WriteString(sourceStyle.Render(field) + "\n")
+		b.WriteString(raw[field] + "\n")
+	}
+	return b.String()
+}
+
+// renderEditor renders all fields of the note being edited, with the editor in place of the
+// field that is currently edited.
+func (m *Model) renderEditor() string {
+	var b strings.Builder
+	for i, field := range m.noteFields {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if i == m.editField {
+			b.WriteString(selStyle.Render(field) + "\n")
+			b.WriteString(m.editor.View() + "\n")
+			continue
+		}
+		b.WriteString(titleStyle.Render(field) + "\n")
+		b.WriteString(m.editValues[i] + "\n")
+	}
+	return lipgloss.NewStyle().Padding(0, 1).Render(b.String())
+}
+
+// renderError renders the failed generation, what was generated and when it is retried.
+func (m *Model) renderError() string {
+	var b strings.Builder
+	what := fmt.Sprintf("Generating %s notes from %s", m.noteModel, m.pdfPath)
+	if m.pages != "" {
+		what += " (pages " + m.pages + ")"
+	}
+	b.WriteString(what + " failed:\n\n")
+	b.WriteString(errStyle.Render(fmt.Sprint(m.err)) + "\n")
+	switch {
+	case !m.retryAt.IsZero():
+		wait := max(time.Until(m.retryAt).Round(time.Second), 0)
+		fmt.Fprintf(&b, "\nThis error is usually temporary. Retrying in %s (attempt %d of %d)...\n", wait, m.retries, maxAutoRetries)
+	case isTransient(m.err):
+		b.WriteString("\nThis error is usually temporary; automatic retries have been exhausted or cancelled.\n")
+	}
+	style := lipgloss.NewStyle().Padding(0, 1)
+	if m.width > 0 {
+		style = style.Width(m.width)
+	}
+	return style.Render(b.String())
 }
 
 func (m *Model) renderDeckSelector() string {
@@ -108,6 +467,50 @@ func (m *Model) renderDeckSelector() string {
 	return listStyle.Render(b.String())
 }
 
+func (m *Model) renderPagesInput() string {
+	return lipgloss.NewStyle().Padding(0, 1).Render(m.pdfPath + "\n\nPages: " + m.pagesInput.View())
+}
+
 func (m *Model) renderNewDeckInput() string {
 	return lipgloss.NewStyle().Padding(0, 1).Render("Deck name: " + m.newDeckInput.View())
 }
+
+func (m *Model) renderNoteModelSelector() string {
+	if len(m.modelList) == 0 {
+		return listStyle.Render("loading note types...")
+	}
+	var b strings.Builder
+	for i, name := range m.modelList {
+		cursor := " "
+		if i == m.modelCursor {
+			cursor = ">"
+		}
+		current := ""
+		if name == m.noteModel {
+			current = " (current)"
+		}
+		line := fmt.Sprintf("%s %s%s", cursor, name, current)
+		if i == m.modelCursor {
+			b.WriteString(selStyle.Render(line) + "\n")
+		} else {
+			b.WriteString(line + "\n")
+		}
+	}
+	return listStyle.Render(b.String())
+}
+
+func (m *Model) renderNoteModelFields() string {
+	if m.modelCursor >= len(m.modelList) {
+		return ""
+	}
+	fields, ok := m.modelFields[m.modelList[m.modelCursor]]
+	if !ok {
+		return lipgloss.NewStyle().Padding(0, 1).Render("loading fields...")
+	}
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Fields") + "\n")
+	for _, field := range fields {
+		b.WriteString("- " + field + "\n")
+	}
+	return lipgloss.NewStyle().Padding(0, 1).Render(b.String())
+}